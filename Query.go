@@ -1,6 +1,7 @@
 package logmunch
 
 import (
+	"fmt"
 	"strings"
 	"time"
 )
@@ -34,14 +35,14 @@ func (q *QueryGroup) Group(l *LogLine) {
 }
 
 type Query struct {
-	// A prefix to group things by
+	// Only fetch lines containing this text
 	Filter string
 
-	// When the log start and end
+	// When the log start and end; zero means unbounded
 	Start time.Time
 	End   time.Time
 
-	// How many lines to fetch
+	// How many lines to fetch; zero or less means no limit
 	Limit int
 
 	// TODO: Filters?
@@ -50,3 +51,59 @@ type Query struct {
 
 	GroupBy []QueryGroup
 }
+
+// Parts of a Query, used to tell which ones a Source handles by itself
+type QueryField int
+
+const (
+	QueryFilter QueryField = 1 << iota
+	QueryLimit
+	QueryTimeRange
+)
+
+func combineQueryFields(fields []QueryField) QueryField {
+	var combined QueryField
+	for _, field := range fields {
+		combined |= field
+	}
+	return combined
+}
+
+// Return a copy of the query with the given fields reset
+func (q Query) Without(fields QueryField) Query {
+	if fields&QueryFilter != 0 {
+		q.Filter = ""
+	}
+	if fields&QueryLimit != 0 {
+		q.Limit = -1
+	}
+	if fields&QueryTimeRange != 0 {
+		q.Start = time.Time{}
+		q.End = time.Time{}
+	}
+	return q
+}
+
+// Set Start and End from human-friendly times (see ParseTime). Empty strings
+// leave the time unbounded.
+func (q *Query) SetTimeRange(start, end string, loc *time.Location) error {
+	now := time.Now()
+
+	startTime, err := ParseTime(start, now, loc)
+	if err != nil {
+		return err
+	}
+
+	endTime, err := ParseTime(end, now, loc)
+	if err != nil {
+		return err
+	}
+
+	if !startTime.IsZero() && !endTime.IsZero() && endTime.Before(startTime) {
+		return fmt.Errorf("End time %s is before start time %s.", endTime, startTime)
+	}
+
+	q.Start = startTime
+	q.End = endTime
+	return nil
+}
@@ -0,0 +1,218 @@
+package logmunch
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// nginx' log_format for the Common and Combined Log Formats
+const (
+	CommonLogFormat   = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`
+	CombinedLogFormat = CommonLogFormat + ` "$http_referer" "$http_user_agent"`
+)
+
+// Stricter patterns for some variables, so other lines aren't mistaken for
+// access logs.
+var accessLogPatterns = map[string]string{
+	"time_local":      `\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`,
+	"time_iso8601":    `\d{4}-\d{2}-\d{2}T[\d:]+(?:Z|[+-][\d:]+)`,
+	"msec":            `\d{10}\.\d+`,
+	"status":          `\d{3}`,
+	"body_bytes_sent": `\d+|-`,
+	"bytes_sent":      `\d+|-`,
+	"request_time":    `[\d.]+|-`,
+}
+
+// Entry names for variables that have another name in logmunch
+var accessLogKeys = map[string]string{
+	"body_bytes_sent": "bytes",
+	"bytes_sent":      "bytes",
+	"http_referer":    "referer",
+	"http_user_agent": "user_agent",
+}
+
+var accessLogVariable = regexp.MustCompile(`\$(\w+)`)
+
+// The time in a log format, with whatever surrounds it up to the whitespace
+var accessLogTime = regexp.MustCompile(`\s*\S*\$(?:time_local|time_iso8601|msec)\b\S*\s*`)
+
+// Parses access logs written with an nginx log_format, ex.
+// CombinedLogFormat. `$request` is split into `method`, `path`, `query`
+// and `protocol`, and the time is taken from `$time_local`,
+// `$time_iso8601` or `$msec`. Other variables become keys of their own.
+type AccessLogParser struct {
+	re        *regexp.Regexp
+	variables []string
+
+	// For lines where the time has already been taken out, ex. with
+	// `-time-format`
+	timeless          *regexp.Regexp
+	timelessVariables []string
+}
+
+// Make a parser for lines written with the given nginx log_format.
+func NewAccessLogParser(format string) (*AccessLogParser, error) {
+	re, variables, err := compileAccessLogFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	parser := &AccessLogParser{re: re, variables: variables}
+
+	// The time is cut out of lines along with the whitespace around it,
+	// leaving a single space if it was in the middle
+	if timeless := strings.TrimSpace(accessLogTime.ReplaceAllString(format, " ")); timeless != format {
+		parser.timeless, parser.timelessVariables, _ = compileAccessLogFormat(timeless)
+	}
+
+	return parser, nil
+}
+
+// Make a regular expression matching lines in the given log format, along
+// with the variables in its groups.
+func compileAccessLogFormat(format string) (*regexp.Regexp, []string, error) {
+	var variables []string
+	var pattern strings.Builder
+	pattern.WriteString("^")
+
+	last := 0
+	for _, match := range accessLogVariable.FindAllStringSubmatchIndex(format, -1) {
+		pattern.WriteString(regexp.QuoteMeta(format[last:match[0]]))
+		last = match[1]
+
+		variable := format[match[2]:match[3]]
+		variables = append(variables, variable)
+
+		// Match up to whatever comes after the variable
+		valuePattern, found := accessLogPatterns[variable]
+		switch {
+		case found:
+		case last == len(format):
+			valuePattern = `.*`
+		case format[last] == '"':
+			valuePattern = `(?:[^"\\]|\\.)*`
+		case format[last] == '$':
+			return nil, nil, fmt.Errorf("Variables $%s and the one after it must be separated in log format '%s'.", variable, format)
+		default:
+			valuePattern = fmt.Sprintf(`[^%s]*`, regexp.QuoteMeta(format[last:last+1]))
+		}
+		fmt.Fprintf(&pattern, "(%s)", valuePattern)
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]))
+	pattern.WriteString("$")
+
+	if len(variables) == 0 {
+		return nil, nil, fmt.Errorf("No variables in log format '%s'.", format)
+	}
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot use log format '%s': %s", format, err)
+	}
+
+	return re, variables, nil
+}
+
+// Access log formats are matched with the whitespace in lines as written
+func (p *AccessLogParser) rawLines() {}
+
+func (p *AccessLogParser) Parse(line string, log *LogLine) bool {
+	values, variables := p.re.FindStringSubmatch(line), p.variables
+	if values == nil && p.timeless != nil && !log.Time.IsZero() {
+		values, variables = p.timeless.FindStringSubmatch(line), p.timelessVariables
+	}
+	if values == nil {
+		return false
+	}
+
+	entries := make(map[string]string)
+	var when time.Time
+
+	for i, variable := range variables {
+		value := values[i+1]
+
+		switch variable {
+		case "time_local":
+			t, err := time.Parse("02/Jan/2006:15:04:05 -0700", value)
+			if err != nil {
+				return false
+			}
+			when = t
+		case "time_iso8601":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return false
+			}
+			when = t
+		case "msec":
+			t, ok := parseEpoch(value)
+			if !ok {
+				return false
+			}
+			when = t
+		case "request":
+			parts := strings.Fields(value)
+			if len(parts) < 2 {
+				entries["request"] = value
+				continue
+			}
+			entries["method"] = parts[0]
+			entries["path"], entries["query"], _ = strings.Cut(parts[1], "?")
+			if entries["query"] == "" {
+				delete(entries, "query")
+			}
+			if len(parts) > 2 {
+				entries["protocol"] = parts[2]
+			}
+		default:
+			key, found := accessLogKeys[variable]
+			if !found {
+				key = variable
+			}
+
+			// nginx and Apache log empty values as `-`
+			if value == "-" || value == "" {
+				if key == "bytes" {
+					entries[key] = "0"
+				}
+				continue
+			}
+			entries[key] = strings.ReplaceAll(value, `\"`, `"`)
+		}
+	}
+
+	if !when.IsZero() {
+		log.Time = when.UTC()
+	}
+	log.Name = "access"
+	for key, value := range entries {
+		log.Entries[key] = value
+	}
+
+	return true
+}
+
+var accessLogParsers []*AccessLogParser
+
+func init() {
+	// nginx' combined format is often extended with the request time
+	for _, format := range []string{CombinedLogFormat + " $request_time", CombinedLogFormat, CommonLogFormat} {
+		parser, err := NewAccessLogParser(format)
+		if err != nil {
+			panic(err)
+		}
+		accessLogParsers = append(accessLogParsers, parser)
+	}
+}
+
+// Parse Combined or Common Log Format lines, with or without the request
+// time at the end.
+func tryAccessLog(line string, log *LogLine) bool {
+	for _, parser := range accessLogParsers {
+		if parser.Parse(line, log) {
+			return true
+		}
+	}
+	return false
+}
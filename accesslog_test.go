@@ -0,0 +1,150 @@
+package logmunch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAccessLogs(t *testing.T) {
+	when := time.Date(2026, 10, 10, 13, 55, 36, 0, time.UTC)
+
+	var tests = []struct {
+		in  string
+		out LogLine
+	}{
+		// Common Log Format
+		{
+			in: `127.0.0.1 - frank [10/Oct/2026:15:55:36 +0200] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			out: LogLine{
+				Time: when,
+				Name: "access",
+				Entries: map[string]string{
+					"remote_addr": "127.0.0.1",
+					"remote_user": "frank",
+					"method":      "GET",
+					"path":        "/apache_pb.gif",
+					"protocol":    "HTTP/1.0",
+					"status":      "200",
+					"bytes":       "2326",
+				},
+			},
+		},
+		// Combined Log Format with escaped quotes and nginx' request time
+		{
+			in: `10.0.0.1 - - [10/Oct/2026:13:55:36 +0000] "POST /users/5/posts?draft=1 HTTP/1.1" 201 - "-" "curl \"7.0\"" 0.123`,
+			out: LogLine{
+				Time: when,
+				Name: "access",
+				Entries: map[string]string{
+					"remote_addr":  "10.0.0.1",
+					"method":       "POST",
+					"path":         "/users/5/posts",
+					"query":        "draft=1",
+					"protocol":     "HTTP/1.1",
+					"status":       "201",
+					"bytes":        "0",
+					"user_agent":   `curl "7.0"`,
+					"request_time": "0.123",
+				},
+			},
+		},
+	}
+
+	// With -time-format, the time is taken out before the line gets here
+	nginx, _ := ParseTimeFormat("%d/%b/%Y:%H:%M:%S %z")
+
+	for _, parser := range []LogParser{{}, {TimeLayout: nginx}} {
+		for _, tt := range tests {
+			out, err := parser.ParseLine(tt.in)
+			if err != nil {
+				t.Errorf("Could not parse `%s`: %s", tt.in, err)
+			} else if !out.Equal(tt.out) {
+				t.Errorf("Expected `%s` to parse as\n\t%#v\nbut got\n\t%#v", tt.in, tt.out, out)
+			}
+		}
+	}
+}
+
+func TestAccessLogParserFormat(t *testing.T) {
+	parser, err := NewAccessLogParser(`$msec $remote_addr "$request" $status $upstream_addr $request_time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := LogParser{Format: parser}.ParseLine(`1791640536.250 10.0.0.1 "GET /health HTTP/1.1" 200 10.0.1.7:8080 0.002`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := LogLine{
+		Time: time.Date(2026, 10, 10, 13, 55, 36, 250000000, time.UTC),
+		Name: "access",
+		Entries: map[string]string{
+			"remote_addr":   "10.0.0.1",
+			"method":        "GET",
+			"path":          "/health",
+			"protocol":      "HTTP/1.1",
+			"status":        "200",
+			"upstream_addr": "10.0.1.7:8080",
+			"request_time":  "0.002",
+		},
+	}
+	if !out.Equal(expected) {
+		t.Errorf("Expected\n\t%#v\nbut got\n\t%#v", expected, out)
+	}
+
+	// Lines in other formats are left alone
+	if _, err := (LogParser{Format: parser}).ParseLine(`2019-01-02T03:04:05Z worker job=7`); err != nil {
+		t.Errorf("Expected the line to parse without the access log format, got %s", err)
+	}
+
+	if _, err := NewAccessLogParser(`$remote_addr$status`); err == nil {
+		t.Errorf("Expected an error for adjacent variables")
+	}
+	if _, err := NewAccessLogParser(`just text`); err == nil {
+		t.Errorf("Expected an error for a format without variables")
+	}
+}
+
+func TestAccessLogParserWhitespace(t *testing.T) {
+	parser, err := NewAccessLogParser("$time_iso8601\t$remote_addr  \"$request\"\t$status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := LogLine{
+		Time: time.Date(2026, 10, 10, 13, 55, 36, 0, time.UTC),
+		Name: "access",
+		Entries: map[string]string{
+			"remote_addr": "10.0.0.1",
+			"method":      "GET",
+			"path":        "/health",
+			"protocol":    "HTTP/1.1",
+			"status":      "200",
+		},
+	}
+
+	in := "2026-10-10T13:55:36Z\t10.0.0.1  \"GET /health HTTP/1.1\"\t200"
+	out, err := LogParser{Format: parser}.ParseLine(in)
+	if err != nil {
+		t.Fatal(err)
+	} else if !out.Equal(expected) {
+		t.Errorf("Expected %q to parse as\n\t%#v\nbut got\n\t%#v", in, expected, out)
+	}
+}
+
+func TestAccessLogFilters(t *testing.T) {
+	line, err := parseLogLine(`10.0.0.1 - - [10/Oct/2026:13:55:36 +0000] "GET /users/5?x=1 HTTP/1.1" 200 5120 "-" "curl/8.0" 0.734`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := MakeBucketizeKey("bytes")(MakeNormaliseUrlPaths("path", []string{"/users/:uid"})(&line))
+
+	if out.Entries["path"] != "/users/:uid" || out.Entries["uid"] != "5" {
+		t.Errorf("Expected the path to be normalised, got `%s`", out.String())
+	}
+	if out.Entries["bytes"] != "5000" {
+		t.Errorf("Expected bytes to be bucketized, got `%s`", out.String())
+	}
+}
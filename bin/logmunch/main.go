@@ -1,22 +1,38 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/msiebuhr/logmunch"
 )
 
-var source string
+// A flag that can be given several times
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ", ") }
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+var sources stringList
+var reorderWindow time.Duration
 var filter string
 var roundTime time.Duration
-var start time.Duration
-var end time.Duration
+var start string
+var end string
+var timezone string
+var logTimezone string
 var outputJson bool
 var filterHerokuLogs bool
 var outputGnuplotCount string
@@ -29,17 +45,51 @@ var normalisePaths string
 var pickKeys string
 var compoundKeys string
 var luaFilter string
+var noCache bool
+var cacheTTL time.Duration
+var replaySpeed string
+var replayNow bool
+var format string
+var logFormat string
+var timeFormat string
+var timeKey string
+var noTime string
+var multilineIndented bool
+var multilineStart string
+var multilineJSON bool
+var multilineMaxLines int
+var multilineTimeout time.Duration
 
 func init() {
-	flag.StringVar(&source, "source", "file:-", "Log source (default: stdin)")
-	flag.StringVar(&filter, "filter", "", "Prefix to fetch")
+	flag.Var(&sources, "source", "Log source or profile from ~/.logmunch (default: stdin); repeat to merge several sources by time")
+	flag.DurationVar(&reorderWindow, "reorder-window", time.Second, "With several sources, how long to wait for a quiet one before passing on lines from the others")
+	flag.StringVar(&filter, "filter", "", "Only fetch lines containing this text")
+	flag.StringVar(&format, "format", "auto", "How to parse lines (see the known formats below)")
+	flag.StringVar(&logFormat, "log-format", "", "Parse access logs written with this nginx log_format (ex. '$remote_addr [$time_local] \"$request\" $status'); overrides -format")
 	flag.StringVar(&luaFilter, "lua-filter", "", "LUA code to filter by (ex. `load > 0.1 and _time_ms > 1234`)")
 
-	flag.DurationVar(&start, "start", time.Hour*-24, "When to start fetching data")
-	flag.DurationVar(&end, "end", time.Duration(0), "When to stop fetching data")
+	flag.StringVar(&start, "start", "", "When to start fetching data (ex. -3d, 'yesterday 14:05', 2026-10-13T14:05:00Z or @1760364300; logentries defaults to -24h)")
+	flag.StringVar(&end, "end", "", "When to stop fetching data (same formats as -start)")
+	flag.StringVar(&timezone, "tz", "Local", "Timezone for -start/-end times without one (ex. UTC or Europe/Copenhagen)")
+	flag.StringVar(&logTimezone, "log-tz", "UTC", "Timezone for timestamps in logs without one (ex. Local or Europe/Copenhagen)")
+	flag.StringVar(&timeFormat, "time-format", "", "Format of timestamps in logs, as a Go layout, strftime (ex. '%d/%b/%Y:%H:%M:%S %z') or 'unix'")
+	flag.StringVar(&timeKey, "time-key", "", "Take the time from this key (ex. ts)")
+	flag.StringVar(&noTime, "no-time", "drop", "What to do with lines without a timestamp: drop, inherit (from the line before), ingest (use the current time) or zero (and add _no_time=true)")
+
+	flag.BoolVar(&multilineIndented, "multiline-indented", false, "Join lines starting with whitespace onto the line before (ex. stack traces)")
+	flag.StringVar(&multilineStart, "multiline-start", "", "Join lines not matching this regular expression onto the line before (ex. '^\\d{4}-')")
+	flag.BoolVar(&multilineJSON, "multiline-json", false, "Join lines onto the line before while its JSON braces are unbalanced")
+	flag.IntVar(&multilineMaxLines, "multiline-max-lines", 500, "Join at most this many lines")
+	flag.DurationVar(&multilineTimeout, "multiline-timeout", time.Second, "Stop joining lines when no more have arrived for this long")
 
 	flag.IntVar(&limit, "limit", -1, "How many lines to fetch")
 
+	flag.BoolVar(&noCache, "no-cache", false, "Don't cache data fetched from remote sources")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 24*time.Hour, "How long to use cached data for")
+
+	flag.StringVar(&replaySpeed, "replay-speed", "", "Replay lines paced by their timestamps at this speed (ex. 1x or 10x)")
+	flag.BoolVar(&replayNow, "replay-now", false, "When replaying, set each line's time to when it is output")
+
 	// Output-control
 	flag.BoolVar(&outputJson, "output-json", false, "Output as lines of JSON")
 	flag.BoolVar(&outputSqlite, "output-sqlite", false, "Output as SQLite database statements")
@@ -59,39 +109,171 @@ func init() {
 }
 
 func main() {
+	loader := logmunch.SourceLoader{}
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(
+			flag.CommandLine.Output(),
+			"\nKnown sources: %s\nKnown formats: %s\n",
+			strings.Join(loader.Schemes(), ", "),
+			strings.Join(logmunch.RegisteredParsers(), ", "),
+		)
+	}
 	flag.Parse()
 
-	loader := logmunch.SourceLoader{}
 	fileLocations := []string{"./.logmunch"}
 	dir, err := homedir.Expand("~/.logmunch")
 	if err == nil {
 		fileLocations = append(fileLocations, dir)
 	}
-	loader.TryLoadConfigs(fileLocations)
+	if err := loader.TryLoadConfigs(fileLocations); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", err)
+	}
 
-	lines := make(chan string, 100)
-	logs := make(chan logmunch.LogLine, 100)
-	filtered := make(chan logmunch.LogLine, 100)
+	// Profiles' flags are used unless given on the command line
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for name, value := range loader.ProfileFlags(sources) {
+		if given[name] || name == "source" {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			fmt.Printf("ERROR: Profile flag -%s=%s: %s\n", name, value, err)
+			os.Exit(1)
+		}
+	}
+
+	if !noCache {
+		if dir, err := logmunch.DefaultCacheDir(); err == nil {
+			loader.Cache = logmunch.NewSourceCache(dir, cacheTTL)
+		}
+	}
 
-	// Get raw log-lines from source
-	go func() {
-		_, err := loader.GetData(source, logmunch.Query{
-			Filter: filter,
-			Limit:  limit,
-			Start:  time.Now().Add(start),
-			End:    time.Now().Add(end),
-		}, lines)
+	// Stop fetching on Ctrl-C, but still output what we've got so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Unset times are left for the source to pick defaults for
+	query := logmunch.Query{
+		Filter: filter,
+		Limit:  limit,
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
 
+	if err := query.SetTimeRange(start, end, loc); err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	logLoc, err := time.LoadLocation(logTimezone)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(sources) == 0 {
+		sources = stringList{"file:-"}
+	}
+
+	lineFormat, err := logmunch.GetParser(format)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if logFormat != "" {
+		lineFormat, err = logmunch.NewAccessLogParser(logFormat)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
 		}
-	}()
+	}
+	timeLayout, err := logmunch.ParseTimeFormat(timeFormat)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	noTimePolicy, err := logmunch.ParseNoTimePolicy(noTime)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
 
-	// Convert text to logs
-	go logmunch.ParseLogEntries(lines, logs)
+	multiline := logmunch.Multiline{
+		Indented: multilineIndented,
+		JSON:     multilineJSON,
+		MaxLines: multilineMaxLines,
+		Timeout:  multilineTimeout,
+	}
+	if multilineStart != "" {
+		multiline.Start, err = regexp.Compile(multilineStart)
+		if err != nil {
+			fmt.Printf("ERROR: -multiline-start: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var speed float64
+	if replaySpeed != "" {
+		speed, err = logmunch.ParseReplaySpeed(replaySpeed)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	loader.Parser = logmunch.LogParser{
+		Format:     lineFormat,
+		TimeLayout: timeLayout,
+		TimeKey:    timeKey,
+		Location:   logLoc,
+		NoTime:     noTimePolicy,
+		Multiline:  multiline,
+	}
 
-	// Filter the loglines
-	filters := []logmunch.Filterer{}
+	// Order lines from several files by the times found with the parser
+	loader.Register("file", logmunch.NewFileSource(loader.Parser))
+
+	// Fetching can be stopped early when we've got enough lines
+	fetchCtx, stopFetching := context.WithCancel(ctx)
+	defer stopFetching()
+
+	logs := make(chan logmunch.LogLine, 100)
+	filtered := make(chan logmunch.LogLine, 100)
+
+	var filters []logmunch.Filterer
+
+	if len(sources) == 1 {
+		// The source's part of the query is enforced by the loader
+		go func() {
+			err := loader.GetLogLines(fetchCtx, sources[0], query, logs)
+
+			if err != nil && err != context.Canceled {
+				fmt.Printf("ERROR: %s\n", err)
+			}
+		}()
+	} else {
+		// Each source gets its part of the query enforced, except the
+		// limit, which goes for all of them together
+		if limit > 0 {
+			filters = append(filters, logmunch.MakeLimitFilter(limit, stopFetching))
+		}
+
+		go func() {
+			err := loader.GetMergedLogLines(fetchCtx, sources, query, reorderWindow, logs)
+
+			if err != nil && err != context.Canceled {
+				fmt.Printf("ERROR: %s\n", err)
+			}
+		}()
+	}
 
 	if filterHerokuLogs {
 		filters = append(filters, logmunch.MakeRemoveHerokuDrainId())
@@ -140,7 +322,13 @@ func main() {
 		filters = append(filters, logmunch.MakeLuaFilter(luaFilter))
 	}
 
-	go logmunch.FilterLogChan(filters, logs, filtered)
+	go logmunch.FilterLogChan(ctx, filters, logs, filtered)
+
+	if replaySpeed != "" {
+		replayed := make(chan logmunch.LogLine, 100)
+		go logmunch.ReplayLogChan(ctx, speed, replayNow, filtered, replayed)
+		filtered = replayed
+	}
 
 	if outputJson {
 		logmunch.DrainJson()(filtered, os.Stdout)
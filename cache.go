@@ -0,0 +1,309 @@
+package logmunch
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Keeps the raw lines fetched by remote sources on disk, so repeating a
+// query (or asking for a part of an earlier one) doesn't fetch everything
+// again.
+//
+// Queries without an end time are cached as fetching up to when they were
+// run, and are served from an earlier such fetch if it is recent enough
+// (see OpenTTL); queries without a start time as fetching from the scheme's
+// default start.
+type SourceCache struct {
+	// Where to keep the cached data
+	Dir string
+
+	// How long cached data is used; zero or less means forever
+	TTL time.Duration
+
+	// How long data fetched without an end time is used, as it lacks the
+	// lines logged since; zero or less means as long as TTL
+	OpenTTL time.Duration
+
+	// Which URL schemes to cache
+	Schemes []string
+
+	// How far back sources fetch without a start time, by scheme. Sources
+	// not listed fetch everything.
+	DefaultSpans map[string]time.Duration
+}
+
+// Describes what a cached file holds
+type cacheEntry struct {
+	Source  string    `json:"source"` // Hash of the config URL
+	Filter  string    `json:"filter"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Limit   int       `json:"limit"`
+	Lines   int       `json:"lines"`
+	Fetched time.Time `json:"fetched"`
+
+	// Fetched without an end time, i.e. up to when it was fetched
+	Open bool `json:"open"`
+}
+
+// Create a cache in `dir`, caching the sources fetching over the network.
+func NewSourceCache(dir string, ttl time.Duration) *SourceCache {
+	return &SourceCache{
+		Dir:     dir,
+		TTL:     ttl,
+		OpenTTL: 5 * time.Minute,
+		Schemes: []string{"logentries", "http", "https"},
+		DefaultSpans: map[string]time.Duration{
+			"logentries": logentriesDefaultSpan,
+		},
+	}
+}
+
+// The default location for the cache, ex. `~/.cache/logmunch`
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "logmunch"), nil
+}
+
+// Wrap the source for `scheme`, so it is served from the cache if possible
+// and stores what it fetches otherwise. `parser` finds the time of cached
+// lines when serving a part of them. Sources for schemes not in `c.Schemes`
+// are returned as-is.
+func (c *SourceCache) Wrap(scheme string, source Source, parser LogParser) Source {
+	cacheable := false
+	for _, s := range c.Schemes {
+		cacheable = cacheable || s == scheme
+	}
+	if !cacheable {
+		return source
+	}
+
+	return func(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+		sourceHash := hashString(config.String())
+		open := query.End.IsZero()
+		query = c.resolve(scheme, query, time.Now())
+
+		if entry, name, found := c.find(sourceHash, query, open); found {
+			defer close(out)
+			err := c.serve(ctx, parser, entry, name, query, out)
+			return query, err
+		}
+
+		return c.store(ctx, source, config, sourceHash, query, open, out)
+	}
+}
+
+// Give the query the time range it covers when run at `now`; up to now
+// without an end time, and from the scheme's default span before the end
+// without a start time.
+func (c *SourceCache) resolve(scheme string, query Query, now time.Time) Query {
+	if query.End.IsZero() {
+		query.End = now
+	}
+	if span, found := c.DefaultSpans[scheme]; found && query.Start.IsZero() {
+		query.Start = query.End.Add(-span)
+	}
+	return query
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Does the entry hold all the lines the query asks for? Queries without an
+// end time (`open`) are covered by entries fetched without one too.
+func (e cacheEntry) covers(sourceHash string, query Query, open bool) bool {
+	if e.Source != sourceHash || e.Filter != query.Filter {
+		return false
+	}
+
+	if e.Start.After(query.Start) || (e.End.Before(query.End) && !(open && e.Open)) {
+		return false
+	}
+
+	// Limited entries are only complete if they didn't hit the limit
+	if e.Limit > 0 && e.Lines >= e.Limit {
+		sameRange := e.Start.Equal(query.Start) && e.End.Equal(query.End)
+		return sameRange && query.Limit > 0 && query.Limit <= e.Limit
+	}
+
+	return true
+}
+
+// Look for a cached entry covering the query, removing expired ones on the
+// way.
+func (c *SourceCache) find(sourceHash string, query Query, open bool) (cacheEntry, string, bool) {
+	metas, _ := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+
+	for _, meta := range metas {
+		name := strings.TrimSuffix(meta, ".json")
+
+		data, err := ioutil.ReadFile(meta)
+		if err != nil {
+			continue
+		}
+
+		var entry cacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			continue
+		}
+
+		if c.TTL > 0 && time.Since(entry.Fetched) > c.TTL {
+			os.Remove(name + ".gz")
+			os.Remove(meta)
+			continue
+		}
+
+		// Open-ended entries are only used for open-ended queries for a
+		// while, though still for the range they covered
+		if open && entry.Open && c.OpenTTL > 0 && time.Since(entry.Fetched) > c.OpenTTL {
+			continue
+		}
+
+		if entry.covers(sourceHash, query, open) {
+			return entry, name, true
+		}
+	}
+
+	return cacheEntry{}, "", false
+}
+
+// Send the lines in the cached entry matching the query
+func (c *SourceCache) serve(ctx context.Context, parser LogParser, entry cacheEntry, name string, query Query, out chan<- string) error {
+	file, err := os.Open(name + ".gz")
+	if err != nil {
+		return err
+	}
+
+	reader, err := decompressReader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	defer reader.Close()
+
+	// Only filter by time when serving a part of the cached range
+	sameRange := entry.Start.Equal(query.Start) && entry.End.Equal(query.End)
+
+	var lineTime time.Time
+	sent := 0
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		// Lines without a timestamp stay with the one before them
+		if logLine, err := parser.ParseLine(line); err == nil {
+			lineTime = logLine.Time
+		}
+
+		if !sameRange && (lineTime.Before(query.Start) || !lineTime.Before(query.End)) {
+			continue
+		}
+
+		if err := sendLine(ctx, out, line); err != nil {
+			return err
+		}
+
+		if sent += 1; query.Limit > 0 && sent >= query.Limit {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Run the source, storing its lines in the cache if it succeeds
+func (c *SourceCache) store(ctx context.Context, source Source, config *url.URL, sourceHash string, query Query, open bool, out chan<- string) (Query, error) {
+	// Fetch uncached if we can't write to the cache
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return source(ctx, config, query, out)
+	}
+
+	tmp, err := ioutil.TempFile(c.Dir, "fetch-*.tmp")
+	if err != nil {
+		return source(ctx, config, query, out)
+	}
+	defer os.Remove(tmp.Name())
+	defer close(out)
+
+	gz := gzip.NewWriter(tmp)
+	lines := make(chan string, 100)
+	count := 0
+	var writeErr error
+
+	type sourceResult struct {
+		query Query
+		err   error
+	}
+	result := make(chan sourceResult, 1)
+	go func() {
+		q, err := source(ctx, config, query, lines)
+		result <- sourceResult{q, err}
+	}()
+
+	for line := range lines {
+		if writeErr == nil {
+			_, writeErr = gz.Write([]byte(line + "\n"))
+		}
+		count += 1
+
+		if err := sendLine(ctx, out, line); err != nil {
+			// Let the source notice the cancellation and finish
+			for range lines {
+			}
+			break
+		}
+	}
+
+	r := <-result
+	if closeErr := gz.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if closeErr := tmp.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+
+	// Only keep complete fetches
+	if r.err != nil || writeErr != nil || ctx.Err() != nil {
+		return r.query, r.err
+	}
+
+	entry := cacheEntry{
+		Source:  sourceHash,
+		Filter:  query.Filter,
+		Start:   query.Start,
+		End:     query.End,
+		Limit:   query.Limit,
+		Lines:   count,
+		Fetched: time.Now(),
+		Open:    open,
+	}
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return r.query, r.err
+	}
+
+	name := filepath.Join(c.Dir, hashString(string(meta)))
+	if os.Rename(tmp.Name(), name+".gz") == nil {
+		ioutil.WriteFile(name+".json", meta, 0600)
+	}
+
+	return r.query, r.err
+}
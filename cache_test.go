@@ -0,0 +1,177 @@
+package logmunch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSourceCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmunch-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC)
+	remoteLines := []string{
+		"2015-06-12T00:00:00Z first",
+		"2015-06-12T01:00:00Z second",
+		"  continuation of second",
+		"2015-06-12T02:00:00Z third",
+	}
+
+	fetches := 0
+	remote := func(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+		defer close(out)
+		fetches += 1
+		for _, line := range remoteLines {
+			out <- line
+		}
+		return query, nil
+	}
+
+	cache := NewSourceCache(dir, time.Hour)
+	cached := cache.Wrap("logentries", remote, LogParser{})
+	config, _ := url.Parse("logentries://:secret@/Test/heroku")
+
+	fetch := func(query Query) []string {
+		out := make(chan string, 10)
+		if _, err := cached(context.Background(), config, query, out); err != nil {
+			t.Errorf("Unexpected error %s", err)
+		}
+
+		lines := []string{}
+		for line := range out {
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	full := Query{Start: base, End: base.Add(3 * time.Hour)}
+
+	var tests = []struct {
+		query   Query
+		lines   []string
+		fetches int
+	}{
+		// Fetched and then served from the cache
+		{full, remoteLines, 1},
+		{full, remoteLines, 1},
+
+		// A part of the cached range
+		{Query{Start: base.Add(time.Hour), End: base.Add(2 * time.Hour)}, remoteLines[1:3], 1},
+		{Query{Start: base, End: base.Add(3 * time.Hour), Limit: 1}, remoteLines[:1], 1},
+
+		// Other filters and larger ranges aren't covered
+		{Query{Start: base, End: base.Add(3 * time.Hour), Filter: "x"}, remoteLines, 2},
+		{Query{Start: base, End: base.Add(4 * time.Hour)}, remoteLines, 3},
+
+		// Open-ended queries are served from an earlier open-ended fetch
+		{Query{Start: base}, remoteLines, 4},
+		{Query{Start: base}, remoteLines, 4},
+		{Query{Start: base.Add(time.Hour)}, remoteLines[1:], 4},
+
+		// Without a start, logentries fetches the last day
+		{Query{}, []string{}, 4},
+	}
+
+	for i, tt := range tests {
+		lines := fetch(tt.query)
+
+		if !reflect.DeepEqual(lines, tt.lines) {
+			t.Errorf("%d: expected %q, got %q", i, tt.lines, lines)
+		}
+		if fetches != tt.fetches {
+			t.Errorf("%d: expected %d fetches, got %d", i, tt.fetches, fetches)
+		}
+	}
+
+	// Open-ended fetches go stale sooner
+	cache.OpenTTL = time.Nanosecond
+	if fetch(Query{Start: base}); fetches != 5 {
+		t.Errorf("Expected a stale open-ended fetch to be fetched again, got %d fetches", fetches)
+	}
+	if fetch(full); fetches != 5 {
+		t.Errorf("Expected the full range to still be cached, got %d fetches", fetches)
+	}
+}
+
+func TestSourceCacheUsesParser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmunch-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC)
+	remoteLines := []string{
+		"12/06/2015 00:00:00 first",
+		"12/06/2015 01:00:00 second",
+		"12/06/2015 02:00:00 third",
+	}
+	remote := func(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+		defer close(out)
+		for _, line := range remoteLines {
+			out <- line
+		}
+		return query, nil
+	}
+
+	cached := NewSourceCache(dir, time.Hour).Wrap("logentries", remote, LogParser{TimeLayout: "02/01/2006 15:04:05"})
+	config, _ := url.Parse("logentries:Test/heroku")
+
+	for _, query := range []Query{
+		{Start: base, End: base.Add(3 * time.Hour)},
+		{Start: base.Add(time.Hour), End: base.Add(2 * time.Hour)},
+	} {
+		out := make(chan string, 10)
+		if _, err := cached(context.Background(), config, query, out); err != nil {
+			t.Fatal(err)
+		}
+		lines := []string{}
+		for line := range out {
+			lines = append(lines, line)
+		}
+
+		if query.Start.Equal(base) {
+			continue
+		}
+		if !reflect.DeepEqual(lines, remoteLines[1:2]) {
+			t.Errorf("Expected %q from the cache, got %q", remoteLines[1:2], lines)
+		}
+	}
+}
+
+func TestSourceCacheExpires(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmunch-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fetches := 0
+	remote := func(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+		defer close(out)
+		fetches += 1
+		return query, nil
+	}
+
+	cache := NewSourceCache(dir, time.Nanosecond)
+	cached := cache.Wrap("https", remote, LogParser{})
+	config, _ := url.Parse("https://example.com/logs")
+	query := Query{Start: time.Unix(0, 0), End: time.Unix(3600, 0)}
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(time.Millisecond)
+		cached(context.Background(), config, query, make(chan string, 1))
+	}
+
+	if fetches != 2 {
+		t.Errorf("Expected expired entries to be fetched again, got %d fetches", fetches)
+	}
+}
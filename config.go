@@ -0,0 +1,174 @@
+package logmunch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// A named source from the config file, used as ex. `-source=prod-router`.
+type Profile struct {
+	// The source URL, ex. `logentries:Prod/heroku`
+	Source string
+
+	// Flags to use with the profile, unless given on the command line,
+	// ex. `"pick": "path,status"`
+	Flags map[string]string
+}
+
+// The TOML config file format:
+//
+//	# Defaults for sources, by scheme
+//	defaults = ["logentries://:${LOGENTRIES_KEY}@pull.logentries.com/"]
+//
+//	[profiles.prod-router]
+//	source = "logentries:Prod/heroku"
+//
+//	[profiles.prod-router.flags]
+//	normalise-paths = "path,/users/:uid"
+//	pick = ["path", "status"]
+//
+// `${VAR}` in URLs is replaced by the environment variable VAR, and the
+// flags can have any value the command line takes.
+type configFile struct {
+	Defaults []string                 `toml:"defaults"`
+	Profiles map[string]configProfile `toml:"profiles"`
+}
+
+type configProfile struct {
+	Source string                 `toml:"source"`
+	Flags  map[string]interface{} `toml:"flags"`
+}
+
+// Check if a config file is in the old format with one URL per line
+func isURLList(data []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if u, err := url.Parse(line); err != nil || u.Scheme == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Replace `${VAR}` and `$VAR` with environment variables, complaining about
+// the ones that aren't set. `$$` gives a literal `$`.
+func expandEnv(value string) (string, error) {
+	missing := []string{}
+	expanded := os.Expand(value, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+
+		v, found := os.LookupEnv(name)
+		if !found {
+			missing = append(missing, name)
+		}
+		return v
+	})
+
+	if len(missing) > 0 {
+		return expanded, fmt.Errorf("Environment variable %s not set.", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// Load a config file in the old URL-per-line format or in TOML
+func (s *SourceLoader) loadConfig(data []byte) error {
+	if isURLList(data) {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			u, err := url.Parse(scanner.Text())
+			if err == nil {
+				s.Config[u.Scheme] = *u
+			}
+		}
+
+		return scanner.Err()
+	}
+
+	var config configFile
+	if err := toml.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	errs := []string{}
+
+	for _, defaults := range config.Defaults {
+		expanded, err := expandEnv(defaults)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		u, err := url.Parse(expanded)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		s.Config[u.Scheme] = *u
+	}
+
+	for name, profile := range config.Profiles {
+		source, err := expandEnv(profile.Source)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Profile %s: %s", name, err))
+			continue
+		}
+
+		flags := make(map[string]string, len(profile.Flags))
+		for flag, value := range profile.Flags {
+			// Lists are given as comma-separated values
+			if list, ok := value.([]interface{}); ok {
+				items := make([]string, len(list))
+				for i, item := range list {
+					items[i] = fmt.Sprint(item)
+				}
+				flags[flag] = strings.Join(items, ",")
+			} else {
+				flags[flag] = fmt.Sprint(value)
+			}
+		}
+
+		s.Profiles[name] = Profile{Source: source, Flags: flags}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, " "))
+	}
+	return nil
+}
+
+// Get the flags to use with the given sources. When several sources have
+// the same flag, the first one wins.
+func (s SourceLoader) ProfileFlags(configUrls []string) map[string]string {
+	flags := make(map[string]string)
+
+	for _, configUrl := range configUrls {
+		profile, found := s.Profiles[configUrl]
+		if !found {
+			continue
+		}
+
+		for flag, value := range profile.Flags {
+			if _, set := flags[flag]; !set {
+				flags[flag] = value
+			}
+		}
+	}
+
+	return flags
+}
@@ -0,0 +1,171 @@
+package logmunch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestTryLoadConfigsURLList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := SourceLoader{}
+	err = s.TryLoadConfigs([]string{
+		filepath.Join(dir, "missing"),
+		writeConfig(t, dir, "old", "logentries://:secret@pull.logentries.com/\nsyslog://0.0.0.0:1514\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, config, err := s.GetConfig("logentries:Test/heroku")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.String() != "logentries://:secret@pull.logentries.com/Test/heroku" {
+		t.Errorf("Unexpected config %s", config)
+	}
+}
+
+func TestTryLoadConfigsProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("LOGMUNCH_TEST_KEY", "secret")
+	defer os.Unsetenv("LOGMUNCH_TEST_KEY")
+
+	s := SourceLoader{}
+	err = s.TryLoadConfigs([]string{writeConfig(t, dir, "new", `
+# Comments are allowed
+defaults = ["logentries://:${LOGMUNCH_TEST_KEY}@pull.logentries.com/"]
+
+[profiles.prod-router]
+source = "logentries:Prod/heroku"
+
+[profiles.prod-router.flags]
+normalise-paths = "path,/users/:uid"
+pick = ["path", "status"]
+limit = 100
+
+[profiles.staging]
+source = "logentries://:${LOGMUNCH_TEST_KEY}@pull.logentries.com/Staging/heroku"
+
+[profiles.staging.flags]
+pick = "path"
+`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, config, err := s.GetConfig("prod-router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.String() != "logentries://:secret@pull.logentries.com/Prod/heroku" {
+		t.Errorf("Unexpected config for prod-router: %s", config)
+	}
+
+	_, config, err = s.GetConfig("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.String() != "logentries://:secret@pull.logentries.com/Staging/heroku" {
+		t.Errorf("Unexpected config for staging: %s", config)
+	}
+
+	flags := s.ProfileFlags([]string{"file:-", "prod-router", "staging"})
+	expected := map[string]string{
+		"normalise-paths": "path,/users/:uid",
+		"pick":            "path,status",
+		"limit":           "100",
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected flags %v, got %v", expected, flags)
+	}
+}
+
+func TestTryLoadConfigsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Unsetenv("LOGMUNCH_TEST_MISSING")
+
+	s := SourceLoader{}
+	err = s.TryLoadConfigs([]string{
+		writeConfig(t, dir, "bad", "[profiles.x\nsource = 1"),
+		writeConfig(t, dir, "good", "[profiles.local]\nsource = \"file:-\""),
+	})
+	if err == nil {
+		t.Errorf("Expected an error for a broken config file")
+	}
+	if _, found := s.Profiles["local"]; !found {
+		t.Errorf("Expected the other files to be loaded anyway")
+	}
+
+	s = SourceLoader{}
+	err = s.TryLoadConfigs([]string{
+		writeConfig(t, dir, "env", "[profiles.x]\nsource = \"logentries://:${LOGMUNCH_TEST_MISSING}@host/\""),
+	})
+	if err == nil {
+		t.Errorf("Expected an error for a missing environment variable")
+	}
+	if _, found := s.Profiles["x"]; found {
+		t.Errorf("Expected the profile with a missing environment variable to be skipped")
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("LOGMUNCH_TEST_KEY", "secret")
+	defer os.Unsetenv("LOGMUNCH_TEST_KEY")
+	os.Unsetenv("LOGMUNCH_TEST_MISSING")
+
+	var tests = []struct {
+		in       string
+		expected string
+		fails    bool
+	}{
+		{"plain", "plain", false},
+		{"${LOGMUNCH_TEST_KEY}", "secret", false},
+		{"$LOGMUNCH_TEST_KEY/x", "secret/x", false},
+		{"$$remote_addr $$status", "$remote_addr $status", false},
+		{"$${LOGMUNCH_TEST_KEY}", "${LOGMUNCH_TEST_KEY}", false},
+		{"$$$LOGMUNCH_TEST_KEY", "$secret", false},
+		{"costs 5$", "costs 5$", false},
+		{"$remote_addr", "", true},
+		{"${LOGMUNCH_TEST_MISSING}", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("expandEnv(%q): expected an error", tt.in)
+			}
+			continue
+		}
+		if err != nil || got != tt.expected {
+			t.Errorf("expandEnv(%q): expected %q, got %q (%v)", tt.in, tt.expected, got, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package logmunch
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A line as written by a container runtime; the program's own output
+// wrapped with the time and stream it was written to.
+type containerLine struct {
+	time    time.Time
+	stream  string
+	partial bool
+	message string
+}
+
+// Docker's json-file logging driver:
+//
+//	{"log":"message\n","stream":"stdout","time":"2019-01-02T03:04:05.123456789Z"}
+type dockerJSONLine struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// Parse a line from Docker's json-file logging driver. Long lines are split
+// up in several entries, where all but the last lack the trailing newline.
+func parseDockerLine(line string) (containerLine, bool) {
+	if !strings.HasPrefix(line, `{"log":`) {
+		return containerLine{}, false
+	}
+
+	var entry dockerJSONLine
+	if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.Time.IsZero() {
+		return containerLine{}, false
+	}
+
+	message := strings.TrimSuffix(entry.Log, "\n")
+	return containerLine{
+		time:    entry.Time,
+		stream:  entry.Stream,
+		partial: message == entry.Log,
+		message: strings.TrimSuffix(message, "\r"),
+	}, true
+}
+
+// Parse a line in the CRI logging format used by Kubernetes:
+//
+//	2019-01-02T03:04:05.123456789Z stdout F message
+//
+// The tag is `F` for full lines and `P` for partial ones, which are continued
+// on the next line from the same stream.
+func parseCRILine(line string) (containerLine, bool) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return containerLine{}, false
+	}
+
+	if parts[1] != "stdout" && parts[1] != "stderr" {
+		return containerLine{}, false
+	}
+
+	// Tags are separated by `:`, but only the first one is defined.
+	tag := strings.SplitN(parts[2], ":", 2)[0]
+	if tag != "F" && tag != "P" {
+		return containerLine{}, false
+	}
+
+	lineTime, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return containerLine{}, false
+	}
+
+	entry := containerLine{
+		time:    lineTime,
+		stream:  parts[1],
+		partial: tag == "P",
+	}
+	if len(parts) == 4 {
+		entry.message = parts[3]
+	}
+	return entry, true
+}
+
+// The time and stream come from the runtime; the message is the program's
+func (entry containerLine) wrapped() wrappedLine {
+	return wrappedLine{
+		Time:    entry.time,
+		Entries: map[string]string{"stream": entry.stream},
+		Message: entry.message,
+	}
+}
+
+// Unwrap a line written by Docker or a CRI runtime.
+func unwrapContainerLine(line string) (containerLine, bool) {
+	if entry, ok := parseDockerLine(line); ok {
+		return entry, true
+	}
+	return parseCRILine(line)
+}
+
+// Join partial container lines from `in` into whole ones and send them
+// unwrapped on `out`. Pieces are joined per stream, and the whole line gets
+// the time of the first piece. Lines that aren't container lines are passed
+// on untouched.
+//
+// Note: Does not close `out`.
+func joinContainerLines(
+	ctx context.Context,
+	in <-chan string,
+	out chan<- string,
+	parse func(string) (containerLine, bool),
+) error {
+	partials := make(map[string]*containerLine)
+
+	for line := range in {
+		entry, ok := parse(line)
+		if !ok {
+			if err := sendLine(ctx, out, line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if previous, found := partials[entry.stream]; found {
+			previous.message += entry.message
+			previous.partial = entry.partial
+			entry = *previous
+		}
+
+		if entry.partial {
+			partials[entry.stream] = &entry
+			continue
+		}
+
+		delete(partials, entry.stream)
+		if err := sendLine(ctx, out, entry.wrapped().String()); err != nil {
+			return err
+		}
+	}
+
+	// Whatever is left was cut off when the log ended
+	streams := make([]string, 0, len(partials))
+	for stream := range partials {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+
+	for _, stream := range streams {
+		if err := sendLine(ctx, out, partials[stream].wrapped().String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Make a source reading container logs from files (as the file-source does)
+// and joining partial lines.
+func containerSource(parse func(string) (containerLine, bool)) Source {
+	return func(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+		defer close(out)
+
+		lines := make(chan string, 100)
+		result := make(chan error, 1)
+		go func() {
+			_, err := FileSource(ctx, config, query, lines)
+			result <- err
+		}()
+
+		joinErr := joinContainerLines(ctx, lines, out, parse)
+
+		// The file-source stops by itself when cancelled
+		err := <-result
+		if joinErr != nil {
+			return query, joinErr
+		}
+		return query, err
+	}
+}
+
+// Read logs written by Docker's json-file logging driver, i.e.
+// `docker:/var/lib/docker/containers/ID/ID-json.log`. Takes the same
+// options as the file-source.
+var DockerSource Source = containerSource(parseDockerLine)
+
+// Read logs in the CRI format written on Kubernetes nodes, i.e.
+// `cri:/var/log/pods/NAMESPACE_POD_UID/CONTAINER/`. Takes the same options as
+// the file-source.
+var CRISource Source = containerSource(parseCRILine)
@@ -0,0 +1,141 @@
+package logmunch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnwrapContainerLine(t *testing.T) {
+	lineTime := time.Date(2019, 1, 2, 3, 4, 5, 123456789, time.UTC)
+
+	var tests = []struct {
+		in  string
+		ok  bool
+		out containerLine
+	}{
+		{
+			in:  `{"log":"hello\n","stream":"stdout","time":"2019-01-02T03:04:05.123456789Z"}`,
+			ok:  true,
+			out: containerLine{time: lineTime, stream: "stdout", message: "hello"},
+		},
+		{
+			in:  `{"log":"hel","stream":"stderr","time":"2019-01-02T03:04:05.123456789Z"}`,
+			ok:  true,
+			out: containerLine{time: lineTime, stream: "stderr", partial: true, message: "hel"},
+		},
+		{
+			in:  `2019-01-02T03:04:05.123456789Z stdout F hello world`,
+			ok:  true,
+			out: containerLine{time: lineTime, stream: "stdout", message: "hello world"},
+		},
+		{
+			in:  `2019-01-02T03:04:05.123456789Z stdout P hello`,
+			ok:  true,
+			out: containerLine{time: lineTime, stream: "stdout", partial: true, message: "hello"},
+		},
+		{
+			in:  `2019-01-02T03:04:05.123456789Z stdout F`,
+			ok:  true,
+			out: containerLine{time: lineTime, stream: "stdout"},
+		},
+		// Not container lines
+		{in: `{"log":"no time"}`},
+		{in: `2019-01-02T03:04:05Z api F hello`},
+		{in: `2019-01-02T03:04:05Z stdout X hello`},
+		{in: `2015-06-12T00:11:22.333Z someName {"num": 123}`},
+	}
+
+	for _, tt := range tests {
+		out, ok := unwrapContainerLine(tt.in)
+		if ok != tt.ok || !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("unwrapContainerLine(`%s`) = %+v, %t; expected %+v, %t", tt.in, out, ok, tt.out, tt.ok)
+		}
+	}
+}
+
+func TestJoinContainerLines(t *testing.T) {
+	in := make(chan string, 10)
+	out := make(chan string, 10)
+
+	in <- `2019-01-02T03:04:05Z stdout P hel`
+	in <- `2019-01-02T03:04:06Z stderr F oops`
+	in <- `2019-01-02T03:04:07Z stdout P lo `
+	in <- `2019-01-02T03:04:08Z stdout F world`
+	in <- `not a container line`
+	in <- `2019-01-02T03:04:09Z stdout P cut off`
+	close(in)
+
+	if err := joinContainerLines(context.Background(), in, out, parseCRILine); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	at := func(sec int) time.Time { return time.Date(2019, 1, 2, 3, 4, sec, 0, time.UTC) }
+	expected := []string{
+		containerLine{time: at(6), stream: "stderr", message: "oops"}.wrapped().String(),
+		containerLine{time: at(5), stream: "stdout", message: "hello world"}.wrapped().String(),
+		`not a container line`,
+		containerLine{time: at(9), stream: "stdout", message: "cut off"}.wrapped().String(),
+	}
+
+	lines := []string{}
+	for line := range out {
+		lines = append(lines, line)
+	}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+}
+
+func TestDockerSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "abc-json.log")
+	ioutil.WriteFile(name, []byte(
+		`{"log":"api at=info ","stream":"stdout","time":"2019-01-02T03:04:05Z"}`+"\n"+
+			`{"log":"status=200\n","stream":"stdout","time":"2019-01-02T03:04:06Z"}`+"\n",
+	), 0644)
+
+	config, _ := url.Parse("docker://" + name)
+	lines := make(chan string, 10)
+	if _, err := DockerSource(context.Background(), config, Query{}, lines); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		containerLine{
+			time:    time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+			stream:  "stdout",
+			message: "api at=info status=200",
+		}.wrapped().String(),
+	}
+
+	out := []string{}
+	for line := range lines {
+		out = append(out, line)
+	}
+
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	logLine, err := parseLogLine(out[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logLine.Name != "api" || logLine.Entries["status"] != "200" || logLine.Entries["stream"] != "stdout" {
+		t.Errorf("Unexpected parse of joined line: %s", logLine)
+	}
+}
@@ -0,0 +1,73 @@
+package logmunch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic bytes at the start of the compressed streams we know how to unpack
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Wraps a decompressing reader, so closing it also closes the underlying
+// stream.
+type decompressedReadCloser struct {
+	io.Reader
+	closeReader func()
+	underlying  io.Closer
+}
+
+func (d *decompressedReadCloser) Close() error {
+	if d.closeReader != nil {
+		d.closeReader()
+	}
+	return d.underlying.Close()
+}
+
+// Sniff the first few bytes of `in` and, if it looks like a gzip, bzip2 or
+// zstd stream, return a reader giving the decompressed data. Anything else is
+// returned as-is.
+func decompressReader(in io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(in)
+
+	// Short (or empty) inputs are fine; they just won't match anything
+	head, _ := buffered.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressedReadCloser{
+			Reader:      gz,
+			closeReader: func() { gz.Close() },
+			underlying:  in,
+		}, nil
+	case bytes.HasPrefix(head, bzip2Magic):
+		return &decompressedReadCloser{
+			Reader:     bzip2.NewReader(buffered),
+			underlying: in,
+		}, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressedReadCloser{
+			Reader:      zr,
+			closeReader: zr.Close,
+			underlying:  in,
+		}, nil
+	}
+
+	return &decompressedReadCloser{Reader: buffered, underlying: in}, nil
+}
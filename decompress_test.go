@@ -0,0 +1,75 @@
+package logmunch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompressReader(t *testing.T) {
+	plain := "first line\nsecond line\n"
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(plain))
+	gz.Close()
+
+	var zstded bytes.Buffer
+	zw, _ := zstd.NewWriter(&zstded)
+	zw.Write([]byte(plain))
+	zw.Close()
+
+	// Output of `printf 'first line\nsecond line\n' | bzip2`
+	bzipped := []byte{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x8b, 0x13,
+		0xe1, 0x84, 0x00, 0x00, 0x04, 0xd1, 0x80, 0x00, 0x10, 0x40, 0x00, 0x0f,
+		0x25, 0x9c, 0x00, 0x20, 0x00, 0x21, 0xa1, 0x32, 0x31, 0x94, 0x20, 0x1a,
+		0x00, 0x91, 0x2a, 0x31, 0x95, 0x68, 0xcb, 0x04, 0x82, 0xfd, 0x57, 0xf1,
+		0x77, 0x24, 0x53, 0x85, 0x09, 0x08, 0xb1, 0x3e, 0x18, 0x40,
+	}
+
+	var tests = []struct {
+		name string
+		in   []byte
+	}{
+		{"plain", []byte(plain)},
+		{"gzip", gzipped.Bytes()},
+		{"bzip2", bzipped},
+		{"zstd", zstded.Bytes()},
+	}
+
+	for _, tt := range tests {
+		r, err := decompressReader(ioutil.NopCloser(bytes.NewReader(tt.in)))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tt.name, err)
+			continue
+		}
+
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+
+		if err != nil && err != io.EOF {
+			t.Errorf("%s: unexpected read error %s", tt.name, err)
+		}
+
+		if string(data) != plain {
+			t.Errorf("%s: expected `%s`, got `%s`", tt.name, plain, data)
+		}
+	}
+}
+
+func TestDecompressReaderShortInput(t *testing.T) {
+	r, err := decompressReader(ioutil.NopCloser(bytes.NewReader([]byte("x"))))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != "x" {
+		t.Errorf("Expected `x`, got `%s`", data)
+	}
+}
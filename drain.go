@@ -9,6 +9,11 @@ import (
 	"time"
 )
 
+// Writes the LogLines on the channel to the writer, closing it when done.
+//
+// Drainers run until their input is closed, which the earlier stages also do
+// when cancelled; thus partial results (ex. aggregated tables) are still
+// written out.
 type Drainer func(<-chan LogLine, io.WriteCloser)
 
 func DrainStandard() func(<-chan LogLine, io.WriteCloser) {
@@ -0,0 +1,171 @@
+package logmunch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long to wait for an interrupted command to exit before killing it
+var execWaitDelay = 5 * time.Second
+
+// Run a command and read lines from its output.
+//
+// Ex. `exec:kubectl logs -f deploy/api`. Add `?stderr=true` to also read
+// lines from stderr; otherwise it is passed through to our own stderr.
+//
+// As the command is part of a URL, `%`, `?` and `#` in it must be written
+// as `%25`, `%3F` and `%23`. Anything else after a `?` or `#` is an error,
+// so a command isn't cut short without notice.
+//
+// Cancelling the context interrupts the command, so it gets to clean up and
+// we get to process whatever it has written so far. Exiting with a non-zero
+// status is reported as an error.
+func ExecSource(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+	defer close(out)
+
+	options, err := url.ParseQuery(config.RawQuery)
+	if err != nil {
+		return query, fmt.Errorf("Invalid options '%s' for exec: (write `?` in the command as %%3F): %s", config.RawQuery, err)
+	}
+	for name := range options {
+		if name != "stderr" {
+			return query, fmt.Errorf("Unknown option '%s' for exec: (write `?` in the command as %%3F).", name)
+		}
+	}
+	if config.Fragment != "" {
+		return query, fmt.Errorf("Unexpected '#%s' after the exec: command (write `#` in the command as %%23).", config.Fragment)
+	}
+
+	commandLine := config.Path
+	if config.Opaque != "" {
+		commandLine, err = url.PathUnescape(config.Opaque)
+		if err != nil {
+			return query, fmt.Errorf("Cannot read the exec: command (write `%%` as %%25): %s", err)
+		}
+	}
+
+	args, err := splitCommandLine(commandLine)
+	if err != nil {
+		return query, err
+	} else if len(args) == 0 {
+		return query, errors.New("No command given to exec:.")
+	}
+
+	readStderr, _ := strconv.ParseBool(options.Get("stderr"))
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = execWaitDelay
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return query, err
+	}
+
+	var stderr io.ReadCloser
+	if readStderr {
+		stderr, err = cmd.StderrPipe()
+		if err != nil {
+			return query, err
+		}
+	} else {
+		cmd.Stderr = os.Stderr
+	}
+
+	if err := cmd.Start(); err != nil {
+		return query, err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- outputLinesAndCloseChan(ctx, stdout, out)
+	}()
+
+	if stderr != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- outputLinesAndCloseChan(ctx, stderr, out)
+		}()
+	}
+
+	// All output must be read before waiting for the command to exit
+	wg.Wait()
+	close(errs)
+
+	if err := cmd.Wait(); ctx.Err() != nil {
+		return query, ctx.Err()
+	} else if err != nil {
+		return query, fmt.Errorf("Command `%s` failed: %s", commandLine, err)
+	}
+
+	for err := range errs {
+		if err != nil {
+			return query, err
+		}
+	}
+
+	return query, nil
+}
+
+// Split a command line into arguments, respecting single and double quotes
+// and backslash escapes.
+func splitCommandLine(line string) ([]string, error) {
+	args := []string{}
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == '\'':
+			current.WriteRune(r)
+		case r == '\\' && i+1 < len(runes):
+			i++
+			current.WriteRune(runes[i])
+			inArg = true
+		case quote == '"':
+			current.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated %c in command `%s`.", quote, line)
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
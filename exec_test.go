@@ -0,0 +1,109 @@
+package logmunch
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out []string
+	}{
+		{"kubectl logs -f deploy/api", []string{"kubectl", "logs", "-f", "deploy/api"}},
+		{`grep "a b" 'c "d"' e\ f`, []string{"grep", "a b", `c "d"`, "e f"}},
+		{`echo "" x`, []string{"echo", "", "x"}},
+		{"  ", []string{}},
+	}
+
+	for _, tt := range tests {
+		args, err := splitCommandLine(tt.in)
+		if err != nil {
+			t.Errorf("splitCommandLine(%s) error: %s", tt.in, err)
+		} else if !reflect.DeepEqual(args, tt.out) {
+			t.Errorf("splitCommandLine(%s): expected %q, got %q", tt.in, tt.out, args)
+		}
+	}
+
+	if _, err := splitCommandLine(`echo "unterminated`); err == nil {
+		t.Errorf("Expected an error for unterminated quotes")
+	}
+}
+
+func runExecSource(t *testing.T, source string) ([]string, error) {
+	config, err := url.Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan string, 10)
+	_, err = ExecSource(context.Background(), config, Query{}, out)
+
+	lines := []string{}
+	for line := range out {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+
+	return lines, err
+}
+
+func TestExecSource(t *testing.T) {
+	lines, err := runExecSource(t, "exec:sh -c 'echo out; echo err >&2'?stderr=true")
+	if err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+	if expected := []string{"err", "out"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+
+	lines, err = runExecSource(t, "exec:sh -c 'echo partial; exit 3'")
+	if err == nil {
+		t.Errorf("Expected an error from non-zero exit status")
+	}
+	if expected := []string{"partial"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+}
+
+func TestExecSourceEscaping(t *testing.T) {
+	lines, err := runExecSource(t, "exec:echo 100%25 a%3Fb %23c")
+	if err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+	if expected := []string{"100% a?b #c"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+
+	// Unescaped, they would cut the command short
+	for _, source := range []string{"exec:echo a?b", "exec:echo a#b", "exec:echo a?stderr=true#b"} {
+		if lines, err := runExecSource(t, source); err == nil {
+			t.Errorf("Expected an error for %s, got %q", source, lines)
+		}
+	}
+}
+
+func TestExecSourceCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	config, _ := url.Parse("exec:sh -c 'echo started; exec sleep 10'")
+	out := make(chan string, 10)
+
+	start := time.Now()
+	_, err := ExecSource(ctx, config, Query{}, out)
+
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Expected the command to be interrupted")
+	}
+	if line := <-out; line != "started" {
+		t.Errorf("Expected output before cancelling, got `%s`", line)
+	}
+}
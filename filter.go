@@ -1,6 +1,7 @@
 package logmunch
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"regexp"
@@ -13,11 +14,23 @@ import (
 // TODO(msiebuhr): CombineKeysFilter
 
 // Run the LogLines on `in` through the given filters and put them on `out`.
-// Note: Closes `out` when `in` does so.
-func FilterLogChan(filters []Filterer, in <-chan LogLine, out chan<- LogLine) {
+// Note: Closes `out` when `in` does so, or the context is cancelled.
+func FilterLogChan(ctx context.Context, filters []Filterer, in <-chan LogLine, out chan<- LogLine) {
 	defer close(out)
 
-	for l := range in {
+	for {
+		var l LogLine
+		var ok bool
+
+		select {
+		case l, ok = <-in:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
 		lp := &l
 		for _, filter := range filters {
 			lp = filter(lp)
@@ -26,12 +39,16 @@ func FilterLogChan(filters []Filterer, in <-chan LogLine, out chan<- LogLine) {
 				goto Next
 			}
 		}
-		out <- *lp
+
+		select {
+		case out <- *lp:
+		case <-ctx.Done():
+			return
+		}
 
 		// Process the next logLine
 	Next:
 	}
-
 }
 
 // Given a log line, transforms and returns the logline (or perhaps a new
@@ -234,3 +251,95 @@ func MakeRemoveHerokuDrainId() func(*LogLine) *LogLine {
 		return in
 	}
 }
+
+// Creates a filter that keeps lines from `start` and until (but not including)
+// `end`. Zero times mean no bound.
+func MakeTimeRangeFilter(start, end time.Time) func(*LogLine) *LogLine {
+	return func(in *LogLine) *LogLine {
+		if in == nil {
+			return nil
+		}
+
+		if !start.IsZero() && in.Time.Before(start) {
+			return nil
+		}
+		if !end.IsZero() && !in.Time.Before(end) {
+			return nil
+		}
+
+		return in
+	}
+}
+
+// Creates a filter that keeps lines where the name or any `key=value` pair
+// contains the given text.
+func MakeTextFilter(text string) func(*LogLine) *LogLine {
+	return func(in *LogLine) *LogLine {
+		if in == nil {
+			return nil
+		}
+
+		if strings.Contains(in.Name, text) {
+			return in
+		}
+
+		for key, value := range in.Entries {
+			if strings.Contains(key+"="+value, text) {
+				return in
+			}
+		}
+
+		return nil
+	}
+}
+
+// Creates a filter that keeps the first `limit` lines and discards the rest.
+// When the limit is reached, `done` is called (ex. to stop fetching more).
+func MakeLimitFilter(limit int, done func()) func(*LogLine) *LogLine {
+	seen := 0
+	return func(in *LogLine) *LogLine {
+		if in == nil || seen >= limit {
+			return nil
+		}
+
+		seen += 1
+		if seen == limit && done != nil {
+			done()
+		}
+
+		return in
+	}
+}
+
+// Creates the filters enforcing the given query. Use it with the part of the
+// query a Source doesn't handle by itself (see SourceLoader.Unhandled).
+func MakeQueryFilters(query Query, done func()) []Filterer {
+	filters := []Filterer{}
+
+	if !query.Start.IsZero() || !query.End.IsZero() {
+		filters = append(filters, MakeTimeRangeFilter(query.Start, query.End))
+	}
+
+	if query.Filter != "" {
+		filters = append(filters, MakeTextFilter(query.Filter))
+	}
+
+	if query.Limit > 0 {
+		filters = append(filters, MakeLimitFilter(query.Limit, done))
+	}
+
+	return filters
+}
+
+// Creates a filter that sets `key` to `value` on all lines, ex. to tag where
+// they came from.
+func MakeTagFilter(key, value string) func(*LogLine) *LogLine {
+	return func(in *LogLine) *LogLine {
+		if in == nil {
+			return nil
+		}
+
+		in.Entries[key] = value
+		return in
+	}
+}
@@ -1,6 +1,8 @@
 package logmunch
 
 import (
+	"context"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -164,3 +166,72 @@ func TestBucketizeKey(t *testing.T) {
 	tests.normalizeTimestamps()
 	tests.run(MakeBucketizeKey("v"), t)
 }
+
+func TestFilterLogChanCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan LogLine)
+	out := make(chan LogLine)
+
+	go FilterLogChan(ctx, []Filterer{NoOpFilter}, in, out)
+
+	in <- NewLogLine(time.Now(), "first", map[string]string{})
+	<-out
+
+	// Nothing more comes in, but cancelling should still close `out`
+	cancel()
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Errorf("Didn't expect more lines after cancelling")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected out to be closed when cancelled")
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	base := time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC)
+	lines := []LogLine{
+		NewLogLine(base.Add(-time.Minute), "web.1", map[string]string{"status": "500"}),
+		NewLogLine(base, "web.1", map[string]string{"status": "500"}),
+		NewLogLine(base.Add(time.Minute), "web.2", map[string]string{"status": "200"}),
+		NewLogLine(base.Add(2*time.Minute), "web.1", map[string]string{"status": "500"}),
+		NewLogLine(base.Add(3*time.Minute), "web.1", map[string]string{"status": "500"}),
+		NewLogLine(base.Add(4*time.Minute), "web.1", map[string]string{"status": "500"}),
+	}
+
+	done := false
+	filters := MakeQueryFilters(Query{
+		Filter: "status=500",
+		Start:  base,
+		End:    base.Add(4 * time.Minute),
+		Limit:  2,
+	}, func() { done = true })
+
+	kept := []time.Time{}
+	for _, l := range lines {
+		lp := &l
+		for _, filter := range filters {
+			lp = filter(lp)
+		}
+		if lp != nil {
+			kept = append(kept, lp.Time)
+		}
+	}
+
+	expected := []time.Time{base, base.Add(2 * time.Minute)}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Expected %v, got %v", expected, kept)
+	}
+
+	if !done {
+		t.Errorf("Expected done() to be called when the limit is reached")
+	}
+}
+
+func TestQueryFiltersEmptyQuery(t *testing.T) {
+	if filters := MakeQueryFilters(Query{Limit: -1}, nil); len(filters) != 0 {
+		t.Errorf("Expected no filters for an empty query, got %d", len(filters))
+	}
+}
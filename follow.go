@@ -0,0 +1,115 @@
+package logmunch
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// How often to check a followed file for new data
+var followPollInterval = 250 * time.Millisecond
+
+// Read lines from the named file like `tail -F`; keep reading appended data
+// after reaching the end, start over if the file is truncated and re-open
+// it if it is replaced (i.e. log rotation by renaming).
+//
+// Like `tail -f`, only lines written after starting are read, unless
+// `fromStart` is given. A file that isn't there yet (or replaces the one
+// being read) is read from the beginning.
+//
+// Runs until the context is cancelled or an error occurs.
+//
+// Note: Does not close `out`.
+func followFile(ctx context.Context, name string, fromStart bool, out chan<- string) error {
+	var file *os.File
+	var reader *bufio.Reader
+	var offset int64
+	partial := ""
+	first := true
+
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	// Send a complete line (if it isn't empty)
+	emit := func(line string) error {
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return sendLine(ctx, out, line)
+		}
+		return nil
+	}
+
+	for {
+		// (Re-)open the file. If it isn't there, it is probably in the
+		// middle of being rotated, so we just try again later.
+		if file == nil {
+			if f, err := os.Open(name); err == nil {
+				file = f
+				reader = bufio.NewReader(f)
+				offset = 0
+
+				if first && !fromStart {
+					if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+						return err
+					}
+				}
+			}
+			first = false
+		}
+
+		if file != nil {
+			// Read everything that's been written
+			for {
+				line, err := reader.ReadString('\n')
+				offset += int64(len(line))
+
+				if err == io.EOF {
+					partial += line
+					break
+				} else if err != nil {
+					return err
+				}
+
+				if err := emit(partial + line); err != nil {
+					return err
+				}
+				partial = ""
+			}
+
+			current, err := os.Stat(name)
+			opened, openedErr := file.Stat()
+
+			if err != nil || openedErr != nil || !os.SameFile(current, opened) {
+				// Rotated away; the old file has been read to the end,
+				// so switch to the new one right away.
+				if err := emit(partial); err != nil {
+					return err
+				}
+				partial = ""
+				file.Close()
+				file = nil
+				continue
+			} else if current.Size() < offset {
+				// Truncated; start over from the beginning
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				reader.Reset(file)
+				offset = 0
+				partial = ""
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(followPollInterval):
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package logmunch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func expectLine(t *testing.T, lines <-chan string, expected string) {
+	select {
+	case line := <-lines:
+		if line != expected {
+			t.Errorf("Expected line `%s`, got `%s`", expected, line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for line `%s`", expected)
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	defer func(d time.Duration) { followPollInterval = d }(followPollInterval)
+	followPollInterval = 5 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "logmunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(name, []byte("first\n"), 0644)
+
+	lines := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+
+	go func() {
+		result <- followFile(ctx, name, true, lines)
+	}()
+
+	expectLine(t, lines, "first")
+
+	// Appending
+	f, _ := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("second\nthi")
+	f.Sync()
+	time.Sleep(20 * time.Millisecond)
+	f.WriteString("rd\n")
+	f.Close()
+
+	expectLine(t, lines, "second")
+	expectLine(t, lines, "third")
+
+	// Rotation by renaming
+	os.Rename(name, name+".1")
+	ioutil.WriteFile(name, []byte("rotated\n"), 0644)
+
+	expectLine(t, lines, "rotated")
+
+	// Truncation
+	ioutil.WriteFile(name, []byte("new\n"), 0644)
+
+	expectLine(t, lines, "new")
+
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFollowFileFromEnd(t *testing.T) {
+	defer func(d time.Duration) { followPollInterval = d }(followPollInterval)
+	followPollInterval = 5 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "logmunch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "app.log")
+	ioutil.WriteFile(name, []byte("old\n"), 0644)
+
+	lines := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+
+	go func() {
+		result <- followFile(ctx, name, false, lines)
+	}()
+
+	// Give it time to open the file before appending
+	time.Sleep(50 * time.Millisecond)
+	f, _ := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("new\n")
+	f.Close()
+
+	expectLine(t, lines, "new")
+
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
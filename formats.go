@@ -0,0 +1,179 @@
+package logmunch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Parses what's left of a line after the timestamp and such has been taken
+// out, setting the name and entries (and time, if it is somewhere else in
+// the line). Returns false if the line isn't in the parser's format.
+type LineParser interface {
+	Parse(line string, log *LogLine) bool
+}
+
+// Use an ordinary function as a LineParser
+type LineParserFunc func(line string, log *LogLine) bool
+
+func (f LineParserFunc) Parse(line string, log *LogLine) bool {
+	return f(line, log)
+}
+
+// LineParsers implementing this get lines with the whitespace in them as
+// written, rather than with runs of it made single spaces.
+type rawLineParser interface {
+	LineParser
+	rawLines()
+}
+
+// Use an ordinary function as a rawLineParser
+type rawLineParserFunc func(line string, log *LogLine) bool
+
+func (f rawLineParserFunc) Parse(line string, log *LogLine) bool {
+	return f(line, log)
+}
+
+func (rawLineParserFunc) rawLines() {}
+
+// Parse what's left of a line with `parser`, as written if it wants that
+func parseBodyWith(parser LineParser, body lineBody, log *LogLine) bool {
+	switch parser := parser.(type) {
+	case autoParser:
+		return parser.parseBody(body, log)
+	case rawLineParser:
+		return parser.Parse(body.raw, log)
+	}
+	return parser.Parse(body.text, log)
+}
+
+var (
+	parsersLock sync.RWMutex
+	parsers     = make(map[string]LineParser)
+	autoOrder   []LineParser
+	autoNames   []string
+)
+
+func init() {
+	// Apache's and nginx' access logs
+	RegisterParser("clf", rawLineParserFunc(tryAccessLog))
+
+	// The somewhat popular `NAME {… JSON …}`
+	RegisterParser("json", LineParserFunc(tryParseOutJSON))
+
+	// Heroku's `d.UUID NAME - - key=val key=val …` format.
+	RegisterParser("heroku", LineParserFunc(tryHerokuLogFmt))
+
+	// Logentries serialize with a='b' (not a="b")
+	RegisterParser("logfmt-tic", LineParserFunc(tryTicEscapedLogFmt))
+
+	// Some prefix text and=then some=logfmt
+	RegisterParser("logfmt", LineParserFunc(tryPrefixedLogFmt))
+
+	// ` SOMETHING - - MESSAGE GOES HERE`
+	RegisterParser("plain", LineParserFunc(tryPlainMessage))
+
+	// Everything is the name
+	RegisterParser("none", LineParserFunc(func(line string, log *LogLine) bool {
+		log.Name = line
+		return true
+	}))
+
+	SetAutoParsers("clf", "json", "heroku", "logfmt-tic", "logfmt", "plain")
+}
+
+// Make a line format available as ex. `-format=name`. Panics if the name is
+// already taken.
+func RegisterParser(name string, parser LineParser) {
+	parsersLock.Lock()
+	defer parsersLock.Unlock()
+
+	if parser == nil {
+		panic("logmunch: RegisterParser parser is nil")
+	}
+	if _, dup := parsers[name]; dup || name == "auto" {
+		panic("logmunch: RegisterParser called twice for format " + name)
+	}
+
+	parsers[name] = parser
+}
+
+// Get the names of all registered formats, including `auto`.
+func RegisteredParsers() []string {
+	parsersLock.RLock()
+	defer parsersLock.RUnlock()
+
+	names := make([]string, 0, len(parsers)+1)
+	names = append(names, "auto")
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Set which formats `auto` tries, in order.
+func SetAutoParsers(names ...string) error {
+	parsersLock.Lock()
+	defer parsersLock.Unlock()
+
+	order := make([]LineParser, len(names))
+	for i, name := range names {
+		parser, found := parsers[name]
+		if !found {
+			return fmt.Errorf("Unknown format '%s'.", name)
+		}
+		order[i] = parser
+	}
+
+	autoOrder = order
+	autoNames = append([]string{}, names...)
+	return nil
+}
+
+// Get the formats `auto` tries, in order.
+func AutoParsers() []string {
+	parsersLock.RLock()
+	defer parsersLock.RUnlock()
+
+	return append([]string{}, autoNames...)
+}
+
+// Get a registered format by name
+func GetParser(name string) (LineParser, error) {
+	if name == "auto" || name == "" {
+		return autoParser{}, nil
+	}
+
+	parsersLock.RLock()
+	parser, found := parsers[name]
+	parsersLock.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("Unknown format '%s' (known: %s).", name, strings.Join(RegisteredParsers(), ", "))
+	}
+	return parser, nil
+}
+
+// Tries the formats set with SetAutoParsers in turn
+type autoParser struct{}
+
+func (p autoParser) Parse(line string, log *LogLine) bool {
+	return p.parseBody(lineBody{text: line, raw: line}, log)
+}
+
+func (autoParser) parseBody(body lineBody, log *LogLine) bool {
+	parsersLock.RLock()
+	order := autoOrder
+	parsersLock.RUnlock()
+
+	for _, parser := range order {
+		if parseBodyWith(parser, body, log) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,203 @@
+package logmunch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogParserFormats(t *testing.T) {
+	line := `2015-06-12T00:11:22.333Z login {"user":"bob","id":5}`
+
+	var tests = []struct {
+		format string
+		out    LogLine
+	}{
+		{
+			format: "auto",
+			out: LogLine{
+				Name:    "login",
+				Entries: map[string]string{"user": "bob", "id": "5"},
+			},
+		},
+		// No key=value pairs, so it's all name
+		{
+			format: "logfmt",
+			out: LogLine{
+				Name:    `login {"user":"bob","id":5}`,
+				Entries: map[string]string{},
+			},
+		},
+		{
+			format: "none",
+			out: LogLine{
+				Name:    `login {"user":"bob","id":5}`,
+				Entries: map[string]string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		format, err := GetParser(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := LogParser{Format: format}.ParseLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tt.out.Time = time.Date(2015, 6, 12, 0, 11, 22, 333000000, time.UTC)
+		if !out.Equal(tt.out) {
+			t.Errorf("Expected -format=%s to parse as\n\t%#v\nbut got\n\t%#v", tt.format, tt.out, out)
+		}
+	}
+
+	if _, err := GetParser("klingon"); err == nil || !strings.Contains(err.Error(), "auto") {
+		t.Errorf("Expected an error listing the known formats, got %v", err)
+	}
+}
+
+func TestLogParserFormatsEnvelopes(t *testing.T) {
+	line := `{"log":"worker job=7\n","stream":"stdout","time":"2019-01-02T03:04:05Z"}`
+
+	// Lines from other sources are only unwrapped with `auto`
+	out, err := parseLogLine(line)
+	if err != nil || out.Name != "worker" || out.Entries["job"] != "7" || out.Entries["stream"] != "stdout" {
+		t.Errorf("Expected -format=auto to unwrap the line, got `%s`, %v", out.String(), err)
+	}
+
+	none, _ := GetParser("none")
+	out, _ = LogParser{Format: none}.ParseLine(line)
+	if out.Name != line || len(out.Entries) != 0 {
+		t.Errorf("Expected -format=none to leave the line alone, got `%s`", out.String())
+	}
+
+	// Lines from the container, journal, Loki and OTLP sources are always
+	// unwrapped, and the format applies to the message
+	when := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	service, message := "worker", "job=7"
+	wrapped := []wrappedLine{
+		otlpWrappedLine(
+			otlpResource{Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpAnyValue{StringValue: &service}}}},
+			otlpLogRecord{TimeUnixNano: "1546398245000000000", Body: &otlpAnyValue{StringValue: &message}},
+		),
+		containerLine{time: when, stream: "stdout", message: "worker job=7"}.wrapped(),
+		lokiEntry{time: when, labels: map[string]string{"stream": "stdout"}, line: "worker job=7"}.wrapped(),
+		journalWrappedLine(map[string]string{"__REALTIME_TIMESTAMP": "1546398245000000", "SYSLOG_IDENTIFIER": "worker", "MESSAGE": "job=7"}),
+	}
+
+	for _, name := range []string{"auto", "logfmt"} {
+		format, _ := GetParser(name)
+		for _, w := range wrapped {
+			out, err := LogParser{Format: format}.ParseLine(w.String())
+			if err != nil || !out.Time.Equal(when) || out.Name != "worker" || out.Entries["job"] != "7" {
+				t.Errorf("Expected -format=%s to unwrap `%s`, got `%s`, %v", name, w.String(), out.String(), err)
+			}
+		}
+	}
+
+	json, _ := GetParser("json")
+	out, err = LogParser{Format: json}.ParseLine(containerLine{time: when, stream: "stdout", message: `worker {"job":7}`}.wrapped().String())
+	if err != nil || out.Name != "worker" || out.Entries["job"] != "7" || out.Entries["stream"] != "stdout" {
+		t.Errorf("Expected -format=json to parse the message, got `%s`, %v", out.String(), err)
+	}
+
+	out, err = LogParser{Format: none}.ParseLine(containerLine{time: when, stream: "stdout", message: "worker job=7"}.wrapped().String())
+	if err != nil || out.Name != "worker job=7" || out.Entries["stream"] != "stdout" {
+		t.Errorf("Expected -format=none to keep the message as the name, got `%s`, %v", out.String(), err)
+	}
+}
+
+// Remove a format registered by a test, so it can be registered again
+func unregisterParser(name string) {
+	parsersLock.Lock()
+	defer parsersLock.Unlock()
+
+	delete(parsers, name)
+}
+
+func TestRegisterParser(t *testing.T) {
+	defer unregisterParser("colons-test")
+	defer SetAutoParsers(AutoParsers()...)
+
+	// Lines like `user_id:1 action:login`
+	RegisterParser("colons-test", LineParserFunc(func(line string, log *LogLine) bool {
+		if !strings.Contains(line, ":") {
+			return false
+		}
+		for _, field := range strings.Fields(line) {
+			parts := strings.SplitN(field, ":", 2)
+			if len(parts) == 2 {
+				log.Entries[parts[0]] = parts[1]
+			}
+		}
+		return true
+	}))
+
+	found := false
+	for _, name := range RegisteredParsers() {
+		found = found || name == "colons-test"
+	}
+	if !found {
+		t.Errorf("Expected colons-test in %v", RegisteredParsers())
+	}
+
+	if err := SetAutoParsers(append([]string{"colons-test"}, AutoParsers()...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := parseLogLine("2015-06-12T00:11:22.333Z user_id:1 action:login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Entries["user_id"] != "1" || out.Entries["action"] != "login" {
+		t.Errorf("Expected the registered parser to be used, got `%s`", out.String())
+	}
+
+	if err := SetAutoParsers("json", "klingon"); err == nil {
+		t.Errorf("Expected an error setting an unknown format")
+	}
+}
+
+func TestRegisterParserTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering `json` again to panic")
+		}
+	}()
+
+	RegisterParser("json", LineParserFunc(tryParseOutJSON))
+}
+
+// Access logs are tried first, so they must not claim lines the other
+// formats would parse.
+func TestAutoParsersAccessLogFirst(t *testing.T) {
+	lines := []string{
+		`2015-06-12T00:11:22.333Z login {"user":"bob","id":5}`,
+		`2015-06-12T00:11:22.333Z api user=bob id=5 path="/x y"`,
+		`2015-06-12T00:11:22.333Z app[web.1]: at=info method=GET path="/" status=200`,
+		`2015-06-12T00:11:22.333Z worker #tic#job=7#took=0.5s`,
+		`2015-06-12T00:11:22.333Z just some words [in brackets] "and quotes" 200 5`,
+		`127.0.0.1 - - not quite an access log "GET / HTTP/1.1" 200 5`,
+	}
+
+	parser := LogParser{}
+	with := make([]LogLine, len(lines))
+	for i, line := range lines {
+		with[i], _ = parser.ParseLine(line)
+	}
+
+	defer SetAutoParsers(AutoParsers()...)
+	if err := SetAutoParsers("json", "heroku", "logfmt-tic", "logfmt", "plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, line := range lines {
+		without, _ := parser.ParseLine(line)
+		if !with[i].Equal(without) {
+			t.Errorf("Expected `%s` to parse the same without clf, got\n\t%#v\nand\n\t%#v", line, with[i], without)
+		}
+	}
+}
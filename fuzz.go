@@ -1,5 +1,7 @@
 package logmunch
 
+import "context"
+
 const (
 	fuzz_good = 1
 	fuzz_meh  = 0
@@ -10,7 +12,7 @@ func Fuzz(data []byte) int {
 	in := make(chan string)
 	out := make(chan LogLine)
 
-	go ParseLogEntries(in, out)
+	go ParseLogEntries(context.Background(), in, out)
 
 	in <- string(data)
 	close(in)
@@ -0,0 +1,124 @@
+package logmunch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "user" || password != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Api-Key") != "key" {
+			http.Error(w, "Missing header", http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", `</export?page=2>; rel="next", </export>; rel="first"`)
+			fmt.Fprintln(w, "first")
+		case "2":
+			fmt.Fprintln(w, "second")
+		}
+	}))
+	defer server.Close()
+
+	var tests = []struct {
+		fragment string
+		lines    []string
+	}{
+		{"header=X-Api-Key:+key", []string{"first"}},
+		{"header=X-Api-Key:+key&paginate=true", []string{"first", "second"}},
+	}
+
+	for _, tt := range tests {
+		config, _ := url.Parse(server.URL + "/export#" + tt.fragment)
+		config.User = url.UserPassword("user", "secret")
+
+		out := make(chan string, 10)
+		_, err := HTTPSource(context.Background(), config, Query{}, out)
+		if err != nil {
+			t.Errorf("HTTPSource(%s) error: %s", config, err)
+			continue
+		}
+
+		lines := []string{}
+		for line := range out {
+			lines = append(lines, line)
+		}
+
+		if !reflect.DeepEqual(lines, tt.lines) {
+			t.Errorf("HTTPSource(%s): expected %q, got %q", config, tt.lines, lines)
+		}
+	}
+}
+
+func TestHTTPSourceBearer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintln(w, "line")
+	}))
+	defer server.Close()
+
+	config, _ := url.Parse(server.URL + "/#bearer=token")
+	out := make(chan string, 10)
+	if _, err := HTTPSource(context.Background(), config, Query{}, out); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	config, _ = url.Parse(server.URL + "/")
+	out = make(chan string, 10)
+	if _, err := HTTPSource(context.Background(), config, Query{}, out); err == nil {
+		t.Errorf("Expected an error without a bearer token")
+	}
+}
+
+func TestHTTPSourceCredentialsStayOnHost(t *testing.T) {
+	leaked := make(chan string, 10)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"Authorization", "X-Api-Key"} {
+			if r.Header.Get(name) != "" {
+				leaked <- name
+			}
+		}
+		fmt.Fprintln(w, "other")
+	}))
+	defer other.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/export":
+			w.Header().Set("Link", "<"+other.URL+`/next>; rel="next"`)
+			fmt.Fprintln(w, "first")
+		case "/moved":
+			http.Redirect(w, r, other.URL+"/moved", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/export", "/moved"} {
+		config, _ := url.Parse(server.URL + path + "#bearer=token&header=X-Api-Key:+key&paginate=true")
+		config.User = url.UserPassword("user", "secret")
+
+		out := make(chan string, 10)
+		if _, err := HTTPSource(context.Background(), config, Query{}, out); err != nil {
+			t.Errorf("HTTPSource(%s) error: %s", path, err)
+		}
+	}
+
+	close(leaked)
+	for name := range leaked {
+		t.Errorf("%s was sent to another host", name)
+	}
+}
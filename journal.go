@@ -0,0 +1,223 @@
+package logmunch
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Largest binary field we'll read from the journal export format
+const maxJournalFieldSize = 64 * 1024 * 1024
+
+// Read `journalctl -o export` output from a file or stdin and send the
+// entries on with their time, name and fields split out. Input in the
+// `journalctl -o json` format is read too.
+//
+// Ex. `journalctl -o export | logmunch -source=journal:` or
+// `journal:/./vm.export.gz`.
+func JournalSource(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+	defer close(out)
+	name := fileSourcePath(config)
+
+	var reader io.ReadCloser
+	var err error
+	if name == "-" || name == "" {
+		reader, err = decompressReader(os.Stdin)
+	} else {
+		reader, err = openFile(name)
+	}
+	if err != nil {
+		return query, err
+	}
+
+	err = readJournalExport(ctx, reader, out)
+	return query, err
+}
+
+// Read entries in the journal export format from `in` and send them
+// unwrapped on `out`. Entries are separated by empty lines and consist of
+// `FIELD=value` lines, or for binary data, the field name on a line by
+// itself followed by the size as a little-endian uint64, the data and a
+// newline.
+//
+// See https://systemd.io/JOURNAL_EXPORT_FORMATS/
+//
+// Note: Does not close `out`.
+func readJournalExport(ctx context.Context, in io.ReadCloser, out chan<- string) error {
+	defer in.Close()
+
+	// Close the input when cancelled, so we aren't stuck waiting for data
+	stop := context.AfterFunc(ctx, func() { in.Close() })
+	defer stop()
+
+	reader := bufio.NewReader(in)
+	fields := make(map[string]string)
+
+	flush := func() error {
+		if len(fields) == 0 {
+			return nil
+		}
+
+		wrapped := journalWrappedLine(fields)
+		fields = make(map[string]string)
+		return sendLine(ctx, out, wrapped.String())
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			// Reading fails once the input is closed; report why we closed it.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "":
+			if flushErr := flush(); flushErr != nil {
+				return flushErr
+			}
+		case len(fields) == 0 && line[0] == '{':
+			if wrapped, ok := unwrapJournalLine(line); ok {
+				line = wrapped.String()
+			}
+			if sendErr := sendLine(ctx, out, line); sendErr != nil {
+				return sendErr
+			}
+		case strings.IndexByte(line, '=') != -1:
+			i := strings.IndexByte(line, '=')
+			fields[line[:i]] = line[i+1:]
+		default:
+			value, readErr := readJournalBinaryField(reader)
+			if readErr != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return fmt.Errorf("Reading journal field %s: %s", line, readErr)
+			}
+			fields[line] = value
+		}
+
+		if err == io.EOF {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return flush()
+		}
+	}
+}
+
+func readJournalBinaryField(reader *bufio.Reader) (string, error) {
+	var size uint64
+	if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+		return "", err
+	}
+
+	if size > maxJournalFieldSize {
+		return "", fmt.Errorf("field too large (%d bytes)", size)
+	}
+
+	// The data is followed by a newline
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", err
+	}
+
+	return string(data[:size]), nil
+}
+
+// Unwrap a line of `journalctl -o json` output; see journalWrappedLine.
+func unwrapJournalLine(line string) (wrappedLine, bool) {
+	if !strings.HasPrefix(line, "{") || !strings.Contains(line, `"__REALTIME_TIMESTAMP"`) {
+		return wrappedLine{}, false
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return wrappedLine{}, false
+	}
+
+	fields := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if value, ok := journalFieldValue(value); ok {
+			fields[key] = value
+		}
+	}
+
+	if _, err := strconv.ParseInt(fields["__REALTIME_TIMESTAMP"], 10, 64); err != nil {
+		return wrappedLine{}, false
+	}
+
+	return journalWrappedLine(fields), true
+}
+
+// Split out the fields of a journal entry. The time comes from
+// `__REALTIME_TIMESTAMP`, the name from `SYSLOG_IDENTIFIER` (or
+// `_SYSTEMD_UNIT`) and `MESSAGE` is parsed like any other line. All other
+// fields go into the entries as they are.
+func journalWrappedLine(fields map[string]string) wrappedLine {
+	wrapped := wrappedLine{Entries: make(map[string]string, len(fields))}
+
+	for key, value := range fields {
+		switch key {
+		case "__REALTIME_TIMESTAMP":
+			if usec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				wrapped.Time = time.UnixMicro(usec).UTC()
+			}
+		case "MESSAGE":
+			wrapped.Message = value
+		default:
+			wrapped.Entries[key] = value
+		}
+	}
+
+	for _, key := range []string{"SYSLOG_IDENTIFIER", "_SYSTEMD_UNIT"} {
+		if name, ok := wrapped.Entries[key]; ok {
+			wrapped.Name = name
+			delete(wrapped.Entries, key)
+			break
+		}
+	}
+
+	return wrapped
+}
+
+// Fields in the journal JSON format are strings, arrays of bytes (for
+// binary data) or arrays of those (for fields given several times).
+func journalFieldValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []interface{}:
+		data := make([]byte, 0, len(v))
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item := item.(type) {
+			case float64:
+				data = append(data, byte(item))
+			default:
+				if s, ok := journalFieldValue(item); ok {
+					values = append(values, s)
+				}
+			}
+		}
+
+		if len(values) == 0 {
+			return string(data), true
+		}
+		return strings.Join(values, ","), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,93 @@
+package logmunch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseJournalLine(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out LogLine
+	}{
+		// Structured message
+		{
+			in: `{"__REALTIME_TIMESTAMP":"1546398245123456","_SYSTEMD_UNIT":"api.service","SYSLOG_IDENTIFIER":"api","_PID":"42","MESSAGE":"at=info status=200"}`,
+			out: LogLine{
+				Time: time.Date(2019, 1, 2, 3, 4, 5, 123456000, time.UTC),
+				Name: "api",
+				Entries: map[string]string{
+					"at":            "info",
+					"status":        "200",
+					"_PID":          "42",
+					"_SYSTEMD_UNIT": "api.service",
+				},
+			},
+		},
+
+		// Plain message and binary fields
+		{
+			in: `{"__REALTIME_TIMESTAMP":"1546398245000000","_SYSTEMD_UNIT":"cron.service","MESSAGE":[104,105],"PRIORITY":"6"}`,
+			out: LogLine{
+				Time: time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+				Name: "cron.service",
+				Entries: map[string]string{
+					"message":  "hi",
+					"PRIORITY": "6",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := parseLogLine(tt.in)
+		if err != nil {
+			t.Errorf("parseLogLine(`%s`) failed: %s", tt.in, err)
+		} else if !out.Equal(tt.out) {
+			t.Errorf("Expected `%s` to parse as\n\t`%s`\nbut got\n\t`%s`", tt.in, tt.out, out)
+		}
+	}
+
+	if _, ok := unwrapJournalLine(`{"MESSAGE":"no time"}`); ok {
+		t.Errorf("Parsed line without __REALTIME_TIMESTAMP as a journal line")
+	}
+}
+
+func TestReadJournalExport(t *testing.T) {
+	var export bytes.Buffer
+	export.WriteString("__CURSOR=s=1\n__REALTIME_TIMESTAMP=1546398245000000\nMESSAGE=first\n\n")
+	export.WriteString("__REALTIME_TIMESTAMP=1546398246000000\nMESSAGE\n")
+	binary.Write(&export, binary.LittleEndian, uint64(12))
+	export.WriteString("two\nline=s\r\n\n")
+	export.WriteString("\n")
+	export.WriteString(`{"__REALTIME_TIMESTAMP":"1546398247000000","MESSAGE":"json"}` + "\n")
+	export.WriteString("__REALTIME_TIMESTAMP=1546398248000000\nMESSAGE=no trailing newline")
+
+	out := make(chan string, 10)
+	if err := readJournalExport(context.Background(), ioutil.NopCloser(&export), out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	at := func(sec int) time.Time { return time.Date(2019, 1, 2, 3, 4, sec, 0, time.UTC) }
+	expected := []string{
+		wrappedLine{Time: at(5), Entries: map[string]string{"__CURSOR": "s=1"}, Message: "first"}.String(),
+		wrappedLine{Time: at(6), Entries: map[string]string{}, Message: "two\nline=s\r\n"}.String(),
+		wrappedLine{Time: at(7), Entries: map[string]string{}, Message: "json"}.String(),
+		wrappedLine{Time: at(8), Entries: map[string]string{}, Message: "no trailing newline"}.String(),
+	}
+
+	lines := []string{}
+	for line := range out {
+		lines = append(lines, line)
+	}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected\n\t%q\ngot\n\t%q", expected, lines)
+	}
+}
@@ -0,0 +1,321 @@
+package logmunch
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Where to fetch logs from
+var logentriesBaseUrl = "https://pull.logentries.com"
+
+var (
+	// How far back to fetch without a start time
+	logentriesDefaultSpan = 24 * time.Hour
+
+	// How much time to fetch per request, unless given with `?chunk=`
+	logentriesDefaultChunk = time.Hour
+
+	// How many times to retry failing requests, and how long to wait
+	// before the first retry (doubling for each one after that)
+	logentriesMaxRetries = 5
+	logentriesRetryDelay = time.Second
+)
+
+// A failed request that's worth trying again
+type transientError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (t *transientError) Error() string { return t.err.Error() }
+
+// Get data from logentries.
+//
+// The time range is fetched in chunks of an hour (set with ex. `?chunk=6h`),
+// in time order. Add ex. `?concurrency=4` to fetch several chunks at once.
+// Rate-limited and failing requests are retried with backoff.
+func LogEntriesSource(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+	defer close(out)
+
+	if config.User == nil {
+		return query, errors.New("No LogEntries password set!")
+	}
+
+	password, gotPassword := config.User.Password()
+
+	if !gotPassword {
+		return query, errors.New("No LogEntries password set!")
+	}
+
+	options := config.Query()
+
+	chunkSize := logentriesDefaultChunk
+	if chunk := options.Get("chunk"); chunk != "" {
+		var err error
+		chunkSize, err = parseRelativeDuration(chunk)
+		if err != nil || chunkSize <= 0 {
+			return query, fmt.Errorf("Invalid logentries chunk size '%s'.", chunk)
+		}
+	}
+
+	concurrency := 1
+	if c := options.Get("concurrency"); c != "" {
+		var err error
+		concurrency, err = strconv.Atoi(c)
+		if err != nil || concurrency < 1 {
+			return query, fmt.Errorf("Invalid logentries concurrency '%s'.", c)
+		}
+	}
+
+	// Default to the last day
+	start, end := query.Start, query.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if start.IsZero() {
+		start = end.Add(-logentriesDefaultSpan)
+	}
+
+	filter, limit := query.Filter, query.Limit
+
+	// Re-set filter and limit, as we process them here
+	query.Filter = ""
+	query.Limit = -1
+
+	urlFor := func(from, to time.Time, limit int) string {
+		logentriesurl := fmt.Sprintf(
+			"%s/%s/hosts/%s/?start=%d&end=%d",
+			logentriesBaseUrl,
+			password,
+			config.Path,
+			from.Unix()*1000,
+			to.Unix()*1000,
+		)
+
+		// Add filter if one is given
+		if filter != "" {
+			logentriesurl = logentriesurl + "&filter=" + url.QueryEscape(filter)
+		}
+
+		if limit > 0 {
+			logentriesurl = fmt.Sprintf("%s&limit=%d", logentriesurl, limit)
+		}
+
+		return logentriesurl
+	}
+
+	// Split the time range into chunks
+	chunkStarts := []time.Time{}
+	for t := start; t.Before(end); t = t.Add(chunkSize) {
+		chunkStarts = append(chunkStarts, t)
+	}
+	chunkEnd := func(i int) time.Time {
+		if i+1 < len(chunkStarts) {
+			return chunkStarts[i+1]
+		}
+		return end
+	}
+
+	sent := 0
+	emit := func(line string) error {
+		if limit > 0 && sent >= limit {
+			return errLimitReached
+		}
+		sent += 1
+		return sendLine(ctx, out, line)
+	}
+
+	if concurrency == 1 {
+		for i, from := range chunkStarts {
+			remaining := -1
+			if limit > 0 {
+				remaining = limit - sent
+				if remaining <= 0 {
+					break
+				}
+			}
+
+			if err := fetchLogentries(ctx, urlFor(from, chunkEnd(i), remaining), emit); err != nil {
+				return query, err
+			}
+		}
+
+		return query, nil
+	}
+
+	// Fetch chunks concurrently, but emit them in order. A slot is only
+	// freed once a chunk has been emitted, so at most `concurrency` chunks
+	// are kept in memory.
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type chunkResult struct {
+		lines []string
+		err   error
+	}
+	results := make([]chan chunkResult, len(chunkStarts))
+	for i := range results {
+		results[i] = make(chan chunkResult, 1)
+	}
+	slots := make(chan struct{}, concurrency)
+
+	go func() {
+		for i, from := range chunkStarts {
+			select {
+			case slots <- struct{}{}:
+			case <-fetchCtx.Done():
+				return
+			}
+
+			go func(i int, from time.Time) {
+				lines := []string{}
+				err := fetchLogentries(fetchCtx, urlFor(from, chunkEnd(i), limit), func(line string) error {
+					lines = append(lines, line)
+					return nil
+				})
+				results[i] <- chunkResult{lines, err}
+			}(i, from)
+		}
+	}()
+
+	for i := range chunkStarts {
+		var result chunkResult
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			return query, ctx.Err()
+		}
+
+		if result.err != nil {
+			return query, result.err
+		}
+
+		for _, line := range result.lines {
+			if err := emit(line); err == errLimitReached {
+				return query, nil
+			} else if err != nil {
+				return query, err
+			}
+		}
+
+		<-slots
+	}
+
+	return query, nil
+}
+
+// Returned when we've got the lines we asked for
+var errLimitReached = errors.New("Limit reached")
+
+// Fetch the given URL, retrying transient errors. On retries, the lines
+// already emitted are skipped.
+func fetchLogentries(ctx context.Context, logentriesurl string, emit func(string) error) error {
+	delay := logentriesRetryDelay
+	emitted := 0
+
+	countingEmit := func(line string) error {
+		emitted += 1
+		return emit(line)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fetchLogentriesOnce(ctx, logentriesurl, emitted, countingEmit)
+		if err == errLimitReached {
+			return nil
+		}
+
+		var transient *transientError
+		if err == nil || !errors.As(err, &transient) || attempt >= logentriesMaxRetries {
+			return err
+		}
+
+		wait := delay
+		if transient.retryAfter > 0 {
+			wait = transient.retryAfter
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		delay *= 2
+	}
+}
+
+func fetchLogentriesOnce(ctx context.Context, logentriesurl string, skip int, emit func(string) error) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", logentriesurl, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &transientError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return &transientError{
+			err:        fmt.Errorf("Logentries returned HTTP %d", resp.StatusCode),
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	} else if resp.StatusCode != 200 {
+		return fmt.Errorf("Logentries returned HTTP %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		b := scanner.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+
+		// Already got this one before retrying
+		if skip > 0 {
+			skip -= 1
+			continue
+		}
+
+		if err := emit(string(b)); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return &transientError{err: err}
+	}
+
+	return nil
+}
+
+// Parse a Retry-After header; either a number of seconds or a HTTP date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if when, err := http.ParseTime(header); err == nil {
+		return time.Until(when)
+	}
+
+	return 0
+}
@@ -0,0 +1,150 @@
+package logmunch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serve one line per requested chunk, failing the first requests for each
+func newLogentriesTestServer(t *testing.T, failures []int) (*httptest.Server, *[]string) {
+	var lock sync.Mutex
+	attempts := make(map[string]int)
+	requested := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/secret/hosts/Test/heroku/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		start := r.URL.Query().Get("start")
+
+		lock.Lock()
+		attempt := attempts[start]
+		attempts[start] += 1
+		requested = append(requested, start)
+		lock.Unlock()
+
+		if attempt < len(failures) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(failures[attempt])
+			return
+		}
+
+		ms, _ := strconv.ParseInt(start, 10, 64)
+		fmt.Fprintf(w, "%s line\n", time.Unix(0, ms*1e6).UTC().Format(time.RFC3339))
+	}))
+
+	return server, &requested
+}
+
+func runLogentriesSource(t *testing.T, server *httptest.Server, options string, query Query) ([]string, error) {
+	defer func(u string, d time.Duration) {
+		logentriesBaseUrl = u
+		logentriesRetryDelay = d
+	}(logentriesBaseUrl, logentriesRetryDelay)
+	logentriesBaseUrl = server.URL
+	logentriesRetryDelay = time.Millisecond
+
+	config := &url.URL{
+		Scheme:   "logentries",
+		User:     url.UserPassword("", "secret"),
+		Path:     "Test/heroku",
+		RawQuery: options,
+	}
+
+	out := make(chan string, 100)
+	_, err := LogEntriesSource(context.Background(), config, query, out)
+
+	lines := []string{}
+	for line := range out {
+		lines = append(lines, line)
+	}
+	return lines, err
+}
+
+func TestLogEntriesSourceChunksAndRetries(t *testing.T) {
+	start := time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC)
+	query := Query{Start: start, End: start.Add(3 * time.Hour)}
+
+	for _, options := range []string{"", "concurrency=2"} {
+		server, requested := newLogentriesTestServer(t, []int{429, 503})
+		lines, err := runLogentriesSource(t, server, options, query)
+		server.Close()
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", options, err)
+		}
+
+		expected := []string{
+			"2015-06-12T00:00:00Z line",
+			"2015-06-12T01:00:00Z line",
+			"2015-06-12T02:00:00Z line",
+		}
+		if !reflect.DeepEqual(lines, expected) {
+			t.Errorf("%s: expected %q, got %q", options, expected, lines)
+		}
+
+		// Tried three times per chunk
+		if len(*requested) != 9 {
+			t.Errorf("%s: expected 9 requests, got %d", options, len(*requested))
+		}
+	}
+}
+
+func TestLogEntriesSourceLimit(t *testing.T) {
+	server, requested := newLogentriesTestServer(t, nil)
+	defer server.Close()
+
+	start := time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC)
+	query := Query{Start: start, End: start.Add(5 * time.Hour), Limit: 2}
+
+	lines, err := runLogentriesSource(t, server, "", query)
+	if err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+	if len(lines) != 2 {
+		t.Errorf("Expected two lines, got %q", lines)
+	}
+	if len(*requested) != 2 {
+		t.Errorf("Expected fetching to stop after two chunks, got %d requests", len(*requested))
+	}
+}
+
+func TestLogEntriesSourcePermanentError(t *testing.T) {
+	server, requested := newLogentriesTestServer(t, []int{403})
+	defer server.Close()
+
+	start := time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC)
+	_, err := runLogentriesSource(t, server, "", Query{Start: start, End: start.Add(time.Hour)})
+
+	if err == nil {
+		t.Errorf("Expected an error on HTTP 403")
+	}
+	if len(*requested) != 1 {
+		t.Errorf("Expected no retries on HTTP 403, got %d requests", len(*requested))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("120"); d != 2*time.Minute {
+		t.Errorf("Expected 2m, got %s", d)
+	}
+
+	when := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(when); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("Expected about an hour, got %s", d)
+	}
+
+	if d := parseRetryAfter("soon"); d != 0 {
+		t.Errorf("Expected 0, got %s", d)
+	}
+}
@@ -0,0 +1,165 @@
+package logmunch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const logplexTestBody = "" +
+	"83 <40>1 2012-11-30T06:45:29+00:00 host app web.3 - State changed from starting to up\n" +
+	"118 <40>1 2012-11-30T06:45:26+00:00 host app web.3 - Starting process with command `bundle exec rackup config.ru -p 24405`"
+
+func postLogplex(handler http.Handler, user *url.Userinfo) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/drain", strings.NewReader(logplexTestBody))
+	r.Header.Set("Content-Type", "application/logplex-1")
+	r.Header.Set("Logplex-Msg-Count", "2")
+	if user != nil {
+		password, _ := user.Password()
+		r.SetBasicAuth(user.Username(), password)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestLogplexHandler(t *testing.T) {
+	out := make(chan string, 10)
+	handler := makeLogplexHandler("/drain", nil, out)
+
+	w := postLogplex(handler, nil)
+	close(out)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected HTTP 204, got %d", w.Code)
+	}
+
+	got := []string{}
+	for line := range out {
+		got = append(got, line)
+	}
+
+	expected := []string{
+		"<40>1 2012-11-30T06:45:29+00:00 host app web.3 - State changed from starting to up",
+		"<40>1 2012-11-30T06:45:26+00:00 host app web.3 - Starting process with command `bundle exec rackup config.ru -p 24405`",
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected\n\t%q\ngot\n\t%q", expected, got)
+	}
+}
+
+func TestLogplexHandlerAuth(t *testing.T) {
+	out := make(chan string, 10)
+	handler := makeLogplexHandler("/drain", url.UserPassword("user", "secret"), out)
+
+	var tests = []struct {
+		user *url.Userinfo
+		code int
+	}{
+		{nil, http.StatusUnauthorized},
+		{url.UserPassword("user", "wrong"), http.StatusUnauthorized},
+		{url.UserPassword("user", "secret"), http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		if w := postLogplex(handler, tt.user); w.Code != tt.code {
+			t.Errorf("Expected HTTP %d with %v, got %d", tt.code, tt.user, w.Code)
+		}
+	}
+}
+
+func TestLogplexHandlerMsgCount(t *testing.T) {
+	var tests = []struct {
+		count string
+		code  int
+	}{
+		{"", http.StatusNoContent},
+		{"2", http.StatusNoContent},
+		{"3", http.StatusBadRequest},
+		{"1", http.StatusBadRequest},
+		{"two", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		out := make(chan string, 10)
+		handler := makeLogplexHandler("/drain", nil, out)
+
+		r := httptest.NewRequest("POST", "/drain", strings.NewReader(logplexTestBody))
+		if tt.count != "" {
+			r.Header.Set("Logplex-Msg-Count", tt.count)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		close(out)
+
+		if w.Code != tt.code {
+			t.Errorf("Expected HTTP %d with count %q, got %d", tt.code, tt.count, w.Code)
+		}
+
+		lines := 0
+		for range out {
+			lines++
+		}
+		if tt.code != http.StatusNoContent && lines != 0 {
+			t.Errorf("Expected no lines with count %q, got %d", tt.count, lines)
+		}
+	}
+}
+
+func TestServeHTTPSourceWaitsForHandlers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(true)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- serveHTTPSource(ctx, &url.URL{Host: address}, "", handler)
+	}()
+
+	// Retry until the server is listening
+	go func() {
+		for ctx.Err() == nil {
+			if resp, err := http.Post("http://"+address+"/", "text/plain", nil); err == nil {
+				resp.Body.Close()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The handler was never called")
+	}
+	cancel()
+
+	if err := <-served; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if !finished.Load() {
+		t.Errorf("Expected the handler to finish before serving stops")
+	}
+}
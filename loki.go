@@ -0,0 +1,317 @@
+package logmunch
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Default address to receive Loki pushes on (as Loki itself)
+const defaultLokiAddress = ":3100"
+
+// Where Loki clients push logs to
+const lokiPushPath = "/loki/api/v1/push"
+
+// Largest push request we accept
+const maxLokiPushSize = 64 * 1024 * 1024
+
+// A log line pushed by a Loki client
+type lokiEntry struct {
+	labels map[string]string
+	time   time.Time
+	line   string
+}
+
+// Receive logs pushed by Promtail and other Loki clients.
+//
+// Ex. `loki://:3100` and point the client at `http://localhost:3100`. Both
+// the JSON and snappy-compressed protobuf formats are accepted. Like the
+// logplex-source, a user/password in the config URL requires basic auth and
+// `?cert=…&key=…` serves HTTPS.
+//
+// Each entry is sent on with the stream labels (and structured metadata) as
+// entries, and its line parsed like any other.
+func LokiSource(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+	defer close(out)
+
+	err := serveHTTPSource(ctx, config, defaultLokiAddress, makeLokiHandler(config.User, out))
+	return query, err
+}
+
+// Create a handler accepting Loki pushes, sending the entries to `out`.
+func makeLokiHandler(user *url.Userinfo, out chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != lokiPushPath {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if user != nil && !logplexAuthorized(r, user) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="logmunch"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		entries, err := readLokiPush(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, entry := range entries {
+			if err := sendLine(r.Context(), out, entry.wrapped().String()); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Read the entries from a push request
+func readLokiPush(r *http.Request) ([]lokiEntry, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxLokiPushSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > maxLokiPushSize {
+		return nil, errors.New("Push request too large")
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return decodeLokiJSON(data)
+	}
+
+	// Protobuf pushes are always snappy-compressed
+	data, err = snappy.Decode(nil, data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeLokiProtobuf(data)
+}
+
+// The JSON push format, i.e.
+//
+//	{"streams": [{"stream": {"app": "api"}, "values": [["1546398245123456789", "line", {"trace_id": "…"}]]}]}
+//
+// where the last element of a value, the structured metadata, is optional.
+type lokiJSONPush struct {
+	Streams []lokiJSONStream `json:"streams"`
+}
+
+type lokiJSONStream struct {
+	Stream map[string]string   `json:"stream"`
+	Values [][]json.RawMessage `json:"values"`
+}
+
+func decodeLokiJSON(data []byte) ([]lokiEntry, error) {
+	var push lokiJSONPush
+	if err := json.Unmarshal(data, &push); err != nil {
+		return nil, err
+	}
+
+	entries := []lokiEntry{}
+	for _, stream := range push.Streams {
+		for _, value := range stream.Values {
+			entry, err := decodeLokiJSONValue(stream.Stream, value)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func decodeLokiJSONValue(labels map[string]string, value []json.RawMessage) (lokiEntry, error) {
+	if len(value) < 2 {
+		return lokiEntry{}, fmt.Errorf("Expected [timestamp, line] in values, got %d elements", len(value))
+	}
+
+	var timestamp, line string
+	if err := json.Unmarshal(value[0], &timestamp); err != nil {
+		return lokiEntry{}, err
+	}
+	if err := json.Unmarshal(value[1], &line); err != nil {
+		return lokiEntry{}, err
+	}
+
+	nsec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return lokiEntry{}, fmt.Errorf("Bad timestamp '%s': %s", timestamp, err)
+	}
+
+	entry := lokiEntry{
+		labels: make(map[string]string, len(labels)),
+		time:   time.Unix(0, nsec).UTC(),
+		line:   line,
+	}
+	for key, value := range labels {
+		entry.labels[key] = value
+	}
+
+	if len(value) > 2 {
+		var metadata map[string]string
+		if err := json.Unmarshal(value[2], &metadata); err != nil {
+			return lokiEntry{}, err
+		}
+		for key, value := range metadata {
+			entry.labels[key] = value
+		}
+	}
+
+	return entry, nil
+}
+
+// Decode a `logproto.PushRequest`:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter {
+//	  google.protobuf.Timestamp timestamp = 1;
+//	  string line = 2;
+//	  repeated LabelPairAdapter structuredMetadata = 3;
+//	}
+//	message LabelPairAdapter { string name = 1; string value = 2; }
+func decodeLokiProtobuf(data []byte) ([]lokiEntry, error) {
+	entries := []lokiEntry{}
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		if field.num != 1 || field.typ != protowire.BytesType {
+			return nil
+		}
+
+		var labels map[string]string
+		var streamEntries [][]byte
+
+		err := walkProtobuf(field.bytes, func(field protobufField) error {
+			var err error
+			switch {
+			case field.typ != protowire.BytesType:
+			case field.num == 1:
+				labels, err = parsePrometheusLabels(string(field.bytes))
+			case field.num == 2:
+				streamEntries = append(streamEntries, field.bytes)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, data := range streamEntries {
+			entry, err := decodeLokiProtobufEntry(labels, data)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+func decodeLokiProtobufEntry(labels map[string]string, data []byte) (lokiEntry, error) {
+	entry := lokiEntry{labels: make(map[string]string, len(labels))}
+	for key, value := range labels {
+		entry.labels[key] = value
+	}
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		if field.typ != protowire.BytesType {
+			return nil
+		}
+
+		switch field.num {
+		case 1:
+			entry.time = decodeProtobufTimestamp(field.bytes)
+		case 2:
+			entry.line = string(field.bytes)
+		case 3:
+			var name, value string
+			err := walkProtobuf(field.bytes, func(field protobufField) error {
+				switch field.num {
+				case 1:
+					name = string(field.bytes)
+				case 2:
+					value = string(field.bytes)
+				}
+				return nil
+			})
+			entry.labels[name] = value
+			return err
+		}
+		return nil
+	})
+
+	return entry, err
+}
+
+// Parse labels on the form `{app="api", env="prod"}`.
+func parsePrometheusLabels(labels string) (map[string]string, error) {
+	parsed := make(map[string]string)
+
+	rest := strings.TrimSpace(labels)
+	if !strings.HasPrefix(rest, "{") || !strings.HasSuffix(rest, "}") {
+		return nil, fmt.Errorf("Cannot parse labels `%s`", labels)
+	}
+	rest = strings.TrimSpace(rest[1 : len(rest)-1])
+
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("Cannot parse labels `%s`", labels)
+		}
+		name := strings.TrimSpace(rest[:eq])
+		rest = strings.TrimSpace(rest[eq+1:])
+
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse labels `%s`", labels)
+		}
+		value, _ := strconv.Unquote(quoted)
+		parsed[name] = value
+
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest[len(quoted):]), ","))
+	}
+
+	return parsed, nil
+}
+
+// The labels (and structured metadata) become entries
+func (entry lokiEntry) wrapped() wrappedLine {
+	return wrappedLine{
+		Time:    entry.time,
+		Entries: entry.labels,
+		Message: entry.line,
+	}
+}
@@ -0,0 +1,179 @@
+package logmunch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func postLoki(handler http.Handler, contentType string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", lokiPushPath, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func parseLokiOutput(t *testing.T, out chan string) []LogLine {
+	close(out)
+
+	lines := []LogLine{}
+	for line := range out {
+		logLine, err := parseLogLine(line)
+		if err != nil {
+			t.Fatalf("Could not parse `%s`: %s", line, err)
+		}
+		lines = append(lines, logLine)
+	}
+	return lines
+}
+
+func TestLokiHandlerJSON(t *testing.T) {
+	out := make(chan string, 10)
+	handler := makeLokiHandler(nil, out)
+
+	w := postLoki(handler, "application/json", `{"streams": [{
+		"stream": {"app": "api", "env": "prod"},
+		"values": [
+			["1546398245123456789", "at=info status=200"],
+			["1546398246000000000", "plain text", {"trace_id": "abc"}]
+		]
+	}]}`)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected HTTP 204, got %d: %s", w.Code, w.Body)
+	}
+
+	expected := []LogLine{
+		{
+			Time:    time.Date(2019, 1, 2, 3, 4, 5, 123456789, time.UTC),
+			Entries: map[string]string{"app": "api", "env": "prod", "at": "info", "status": "200"},
+		},
+		{
+			Time:    time.Date(2019, 1, 2, 3, 4, 6, 0, time.UTC),
+			Name:    "plain text",
+			Entries: map[string]string{"app": "api", "env": "prod", "trace_id": "abc"},
+		},
+	}
+
+	lines := parseLokiOutput(t, out)
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(lines))
+	}
+
+	for i := range expected {
+		if !lines[i].Equal(expected[i]) {
+			t.Errorf("Expected\n\t`%s`\ngot\n\t`%s`", expected[i].String(), lines[i].String())
+		}
+	}
+}
+
+func TestLokiHandlerProtobuf(t *testing.T) {
+	var timestamp []byte
+	timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, 1546398245)
+	timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, 5000)
+
+	var metadata []byte
+	metadata = protowire.AppendTag(metadata, 1, protowire.BytesType)
+	metadata = protowire.AppendString(metadata, "trace_id")
+	metadata = protowire.AppendTag(metadata, 2, protowire.BytesType)
+	metadata = protowire.AppendString(metadata, "abc")
+
+	var entry []byte
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, timestamp)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendString(entry, `worker {"num": 123}`)
+	entry = protowire.AppendTag(entry, 3, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, metadata)
+
+	var stream []byte
+	stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+	stream = protowire.AppendString(stream, `{app="api", msg="say \"hi\""}`)
+	stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+	stream = protowire.AppendBytes(stream, entry)
+	stream = protowire.AppendTag(stream, 3, protowire.VarintType)
+	stream = protowire.AppendVarint(stream, 12345) // hash
+
+	var push []byte
+	push = protowire.AppendTag(push, 1, protowire.BytesType)
+	push = protowire.AppendBytes(push, stream)
+
+	out := make(chan string, 10)
+	handler := makeLokiHandler(nil, out)
+
+	w := postLoki(handler, "application/x-protobuf", string(snappy.Encode(nil, push)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected HTTP 204, got %d: %s", w.Code, w.Body)
+	}
+
+	expected := LogLine{
+		Time: time.Date(2019, 1, 2, 3, 4, 5, 5000, time.UTC),
+		Name: "worker",
+		Entries: map[string]string{
+			"app":      "api",
+			"msg":      `say "hi"`,
+			"trace_id": "abc",
+			"num":      "123",
+		},
+	}
+
+	lines := parseLokiOutput(t, out)
+	if len(lines) != 1 || !lines[0].Equal(expected) {
+		t.Errorf("Expected\n\t%v\ngot\n\t%v", []LogLine{expected}, lines)
+	}
+}
+
+func TestLokiHandlerErrors(t *testing.T) {
+	out := make(chan string, 10)
+	handler := makeLokiHandler(nil, out)
+
+	if w := postLoki(handler, "application/json", `{"streams": [{"values": [["soon", "x"]]}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected HTTP 400 for a bad timestamp, got %d", w.Code)
+	}
+
+	if w := postLoki(handler, "application/x-protobuf", "not snappy"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected HTTP 400 for a bad protobuf push, got %d", w.Code)
+	}
+
+	r := httptest.NewRequest("GET", lokiPushPath, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected HTTP 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestParsePrometheusLabels(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out map[string]string
+	}{
+		{`{}`, map[string]string{}},
+		{`{app="api"}`, map[string]string{"app": "api"}},
+		{`{app="api", env = "prod",}`, map[string]string{"app": "api", "env": "prod"}},
+		{`{path="a,b=\"c\""}`, map[string]string{"path": `a,b="c"`}},
+		{`app="api"`, nil},
+		{`{app=api}`, nil},
+	}
+
+	for _, tt := range tests {
+		out, err := parsePrometheusLabels(tt.in)
+		if tt.out == nil {
+			if err == nil {
+				t.Errorf("Expected error parsing `%s`, got %v", tt.in, out)
+			}
+		} else if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("parsePrometheusLabels(`%s`) = %v, %v; expected %v", tt.in, out, err, tt.out)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package logmunch
+
+import (
+	"container/heap"
+	"context"
+	"time"
+)
+
+// A stream of raw lines and the next line waiting to be sent from it
+type mergeCursor struct {
+	in     <-chan string
+	parser LogParser
+	line   string
+	time   time.Time
+}
+
+// Advance the cursor to the next line on its channel. Lines without a
+// timestamp inherit the time of the line before them, so they stay next to
+// it in the merged output.
+func (c *mergeCursor) next() bool {
+	line, ok := <-c.in
+	if !ok {
+		return false
+	}
+
+	c.line = line
+	if logLine, err := c.parser.ParseLine(line); err == nil {
+		c.time = logLine.Time
+	}
+	return true
+}
+
+// Min-heap of cursors, ordered by the time of their next line
+type mergeHeap []*mergeCursor
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].time.Before(h[j].time) }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeCursor)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// Merge several streams of raw lines, each sorted by time, into one stream
+// ordered by the timestamps `parser` finds in the lines.
+//
+// Note: Does not close `out`.
+func mergeLinesByTime(ctx context.Context, parser LogParser, ins []<-chan string, out chan<- string) error {
+	h := make(mergeHeap, 0, len(ins))
+
+	for _, in := range ins {
+		c := &mergeCursor{in: in, parser: parser}
+		if c.next() {
+			h = append(h, c)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		c := h[0]
+		if err := sendLine(ctx, out, c.line); err != nil {
+			return err
+		}
+
+		if c.next() {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	return nil
+}
+
+// Merge several streams of LogLines into one ordered by time, waiting at
+// most `window` for a quiet stream to catch up before passing on what the
+// others have. A zero (or negative) window waits as long as it takes, giving
+// a strict ordering of streams that are each ordered.
+//
+// Note: Closes `out` when all inputs are closed, or the context is cancelled.
+func MergeLogLines(ctx context.Context, ins []<-chan LogLine, window time.Duration, out chan<- LogLine) {
+	defer close(out)
+
+	type event struct {
+		input int
+		line  LogLine
+		ok    bool
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Read one line at a time from each input, waiting for it to be passed
+	// on before reading the next.
+	events := make(chan event)
+	next := make([]chan struct{}, len(ins))
+	for i, in := range ins {
+		next[i] = make(chan struct{}, 1)
+
+		go func(i int, in <-chan LogLine) {
+			for {
+				var e event
+				select {
+				case e.line, e.ok = <-in:
+				case <-ctx.Done():
+					return
+				}
+
+				e.input = i
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+
+				if !e.ok {
+					return
+				}
+
+				select {
+				case <-next[i]:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i, in)
+	}
+
+	pending := make([]*LogLine, len(ins))
+	received := make([]time.Time, len(ins))
+	open := len(ins)
+
+	for {
+		// Find the earliest pending line, and when we stop waiting for the
+		// inputs that haven't got one
+		oldest := -1
+		waiting := open
+		deadline := time.Time{}
+		for i, line := range pending {
+			if line == nil {
+				continue
+			}
+			waiting -= 1
+			if oldest == -1 || line.Time.Before(pending[oldest].Time) {
+				oldest = i
+			}
+			if deadline.IsZero() || received[i].Add(window).Before(deadline) {
+				deadline = received[i].Add(window)
+			}
+		}
+
+		if oldest == -1 && open == 0 {
+			return
+		}
+
+		// Everyone has something to say, or we've waited long enough
+		if oldest != -1 && (waiting == 0 || (window > 0 && !time.Now().Before(deadline))) {
+			select {
+			case out <- *pending[oldest]:
+			case <-ctx.Done():
+				return
+			}
+			pending[oldest] = nil
+			next[oldest] <- struct{}{}
+			continue
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if oldest != -1 && window > 0 {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+
+		select {
+		case e := <-events:
+			if !e.ok {
+				open -= 1
+				break
+			}
+			line := e.line
+			pending[e.input] = &line
+			received[e.input] = time.Now()
+		case <-timeout:
+		case <-ctx.Done():
+			return
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
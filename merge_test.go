@@ -0,0 +1,115 @@
+package logmunch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeLinesByTime(t *testing.T) {
+	inputs := [][]string{
+		{
+			"2015-06-12T00:00:01Z a first",
+			"2015-06-12T00:00:04Z a second",
+			"  continuation of a second",
+		},
+		{
+			"2015-06-12T00:00:02Z b first",
+			"2015-06-12T00:00:03Z b second",
+			"2015-06-12T00:00:05Z b third",
+		},
+		{},
+	}
+
+	chans := make([]<-chan string, len(inputs))
+	for i, lines := range inputs {
+		c := make(chan string, len(lines))
+		for _, line := range lines {
+			c <- line
+		}
+		close(c)
+		chans[i] = c
+	}
+
+	out := make(chan string, 10)
+	mergeLinesByTime(context.Background(), LogParser{}, chans, out)
+	close(out)
+
+	got := []string{}
+	for line := range out {
+		got = append(got, line)
+	}
+
+	expected := []string{
+		"2015-06-12T00:00:01Z a first",
+		"2015-06-12T00:00:02Z b first",
+		"2015-06-12T00:00:03Z b second",
+		"2015-06-12T00:00:04Z a second",
+		"  continuation of a second",
+		"2015-06-12T00:00:05Z b third",
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected\n\t%q\ngot\n\t%q", expected, got)
+	}
+}
+
+func TestMergeLogLines(t *testing.T) {
+	base := time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int, name string) LogLine {
+		return NewLogLine(base.Add(time.Duration(seconds)*time.Second), name, map[string]string{})
+	}
+
+	a := make(chan LogLine, 10)
+	b := make(chan LogLine, 10)
+	out := make(chan LogLine, 10)
+
+	a <- at(1, "a1")
+	a <- at(4, "a4")
+	close(a)
+	b <- at(2, "b2")
+	b <- at(3, "b3")
+	b <- at(5, "b5")
+	close(b)
+
+	MergeLogLines(context.Background(), []<-chan LogLine{a, b}, 0, out)
+
+	names := []string{}
+	for l := range out {
+		names = append(names, l.Name)
+	}
+
+	if expected := []string{"a1", "b2", "b3", "a4", "b5"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %q, got %q", expected, names)
+	}
+}
+
+func TestMergeLogLinesWindow(t *testing.T) {
+	base := time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC)
+
+	fast := make(chan LogLine, 10)
+	quiet := make(chan LogLine)
+	out := make(chan LogLine, 10)
+
+	go MergeLogLines(context.Background(), []<-chan LogLine{fast, quiet}, 10*time.Millisecond, out)
+
+	// The quiet input doesn't hold up the other for longer than the window
+	fast <- NewLogLine(base, "fast", map[string]string{})
+
+	select {
+	case l := <-out:
+		if l.Name != "fast" {
+			t.Errorf("Expected line 'fast', got %s", l.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the line to be passed on after the window")
+	}
+
+	close(fast)
+	close(quiet)
+
+	if _, ok := <-out; ok {
+		t.Errorf("Expected out to be closed")
+	}
+}
@@ -0,0 +1,130 @@
+package logmunch
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Rules for joining lines into multi-line events, ex. stack traces or
+// pretty-printed JSON. A line is joined onto the event before it if any of
+// the enabled rules says so.
+type Multiline struct {
+	// Lines starting with a space or tab continue the event before
+	Indented bool
+
+	// Lines not matching this start a new event
+	Start *regexp.Regexp
+
+	// Lines continue the event before while its JSON braces are unbalanced
+	JSON bool
+
+	// Pass events on when they reach this many lines; 0 means no limit
+	MaxLines int
+
+	// Pass events on when no line has arrived for this long; 0 means wait
+	// for the next line
+	Timeout time.Duration
+}
+
+// Are any of the rules enabled?
+func (m Multiline) Enabled() bool {
+	return m.Indented || m.Start != nil || m.JSON
+}
+
+// Does `line` continue an event with `depth` unclosed JSON braces?
+func (m Multiline) continues(line string, depth int) bool {
+	if m.JSON && depth > 0 {
+		return true
+	}
+	if m.Indented && line != "" && (line[0] == ' ' || line[0] == '\t') {
+		return true
+	}
+	if m.Start != nil && !m.Start.MatchString(line) {
+		return true
+	}
+	return false
+}
+
+// Join the lines on `in` into events with newlines in them and put them on
+// `out`.
+// Note: Closes `out` when `in` does so, or the context is cancelled.
+func (m Multiline) JoinLines(ctx context.Context, in <-chan string, out chan<- string) {
+	defer close(out)
+
+	var event []string
+	var depth int
+	var timeout <-chan time.Time
+
+	flush := func() error {
+		if len(event) == 0 {
+			return nil
+		}
+		joined := strings.Join(event, "\n")
+		event = nil
+		depth = 0
+		timeout = nil
+		return sendLine(ctx, out, joined)
+	}
+
+	for {
+		select {
+		case line, ok := <-in:
+			if !ok {
+				flush()
+				return
+			}
+
+			if len(event) > 0 && !m.continues(line, depth) {
+				if err := flush(); err != nil {
+					return
+				}
+			}
+
+			event = append(event, line)
+			if m.JSON {
+				if depth += jsonDepth(line); depth < 0 {
+					depth = 0
+				}
+			}
+
+			if m.MaxLines > 0 && len(event) >= m.MaxLines {
+				if err := flush(); err != nil {
+					return
+				}
+			} else if m.Timeout > 0 {
+				timeout = time.After(m.Timeout)
+			}
+		case <-timeout:
+			if err := flush(); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// How many more JSON objects `line` opens than it closes, ignoring braces in
+// strings.
+func jsonDepth(line string) int {
+	depth := 0
+	inString := false
+
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		}
+	}
+
+	return depth
+}
@@ -0,0 +1,159 @@
+package logmunch
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func joinLines(m Multiline, lines ...string) []string {
+	in := make(chan string, len(lines))
+	out := make(chan string)
+	for _, line := range lines {
+		in <- line
+	}
+	close(in)
+
+	go m.JoinLines(context.Background(), in, out)
+
+	joined := []string{}
+	for line := range out {
+		joined = append(joined, line)
+	}
+	return joined
+}
+
+func TestMultilineJoinLines(t *testing.T) {
+	trace := []string{
+		"2019-01-02T03:04:05Z api Exception in thread main",
+		"\tat Main.run(Main.java:42)",
+		"    at Main.main(Main.java:7)",
+		"Caused by: java.io.IOException",
+		"\tat Disk.read(Disk.java:3)",
+		"2019-01-02T03:04:06Z api done",
+	}
+
+	var tests = []struct {
+		name      string
+		multiline Multiline
+		in        []string
+		out       []string
+	}{
+		{
+			name:      "indented",
+			multiline: Multiline{Indented: true},
+			in:        trace,
+			out: []string{
+				strings.Join(trace[0:3], "\n"),
+				strings.Join(trace[3:5], "\n"),
+				trace[5],
+			},
+		},
+		{
+			name:      "start",
+			multiline: Multiline{Start: regexp.MustCompile(`^\d{4}-`)},
+			in:        trace,
+			out:       []string{strings.Join(trace[0:5], "\n"), trace[5]},
+		},
+		{
+			name:      "max lines",
+			multiline: Multiline{Start: regexp.MustCompile(`^\d{4}-`), MaxLines: 2},
+			in:        trace,
+			out: []string{
+				strings.Join(trace[0:2], "\n"),
+				strings.Join(trace[2:4], "\n"),
+				trace[4],
+				trace[5],
+			},
+		},
+		{
+			name:      "json",
+			multiline: Multiline{JSON: true},
+			in: []string{
+				`2019-01-02T03:04:05Z api {`,
+				`  "user": {"id": 5},`,
+				`  "note": "} not the end"`,
+				`}`,
+				`2019-01-02T03:04:06Z api done`,
+			},
+			out: []string{
+				"2019-01-02T03:04:05Z api {\n  \"user\": {\"id\": 5},\n  \"note\": \"} not the end\"\n}",
+				`2019-01-02T03:04:06Z api done`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		out := joinLines(tt.multiline, tt.in...)
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("%s: Expected\n\t%q\nbut got\n\t%q", tt.name, tt.out, out)
+		}
+	}
+}
+
+func TestMultilineTimeout(t *testing.T) {
+	in := make(chan string)
+	out := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go Multiline{Indented: true, Timeout: 10 * time.Millisecond}.JoinLines(ctx, in, out)
+
+	in <- "api Exception"
+	in <- "\tat Main.run(Main.java:42)"
+
+	select {
+	case line := <-out:
+		if line != "api Exception\n\tat Main.run(Main.java:42)" {
+			t.Errorf("Unexpected line %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the event to be passed on after the timeout")
+	}
+}
+
+func TestParseMultilineEvents(t *testing.T) {
+	when := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var tests = []struct {
+		in  string
+		out LogLine
+	}{
+		{
+			in: "2019-01-02T03:04:05Z api Exception user=5\n\tat Main.run(Main.java:42)\n\tat Main.main(Main.java:7)",
+			out: LogLine{
+				Time: when,
+				Name: "api Exception",
+				Entries: map[string]string{
+					"user":         "5",
+					"continuation": "\tat Main.run(Main.java:42)\n\tat Main.main(Main.java:7)",
+				},
+			},
+		},
+		{
+			in: "2019-01-02T03:04:05Z api {\n  \"user\": 5\n}",
+			out: LogLine{
+				Time:    when,
+				Name:    "api",
+				Entries: map[string]string{"user": "5"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := parseLogLine(tt.in)
+		if err != nil {
+			t.Errorf("Could not parse %q: %s", tt.in, err)
+		} else if !out.Equal(tt.out) {
+			t.Errorf("Expected %q to parse as\n\t%#v\nbut got\n\t%#v", tt.in, tt.out, out)
+		}
+	}
+
+	out, _ := parseLogLine(tests[0].in)
+	if s := out.String(); strings.Contains(s, "\n") || !strings.Contains(s, `\n`+"\tat Main.main") {
+		t.Errorf("Expected the newlines to be escaped, got %q", s)
+	}
+}
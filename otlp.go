@@ -0,0 +1,478 @@
+package logmunch
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Default address to receive OTLP/HTTP on
+const defaultOTLPAddress = ":4318"
+
+// Where OTLP/HTTP clients export logs to
+const otlpLogsPath = "/v1/logs"
+
+// Largest export request we accept
+const maxOTLPRequestSize = 64 * 1024 * 1024
+
+// An OTLP `ExportLogsServiceRequest` in its JSON form. Only the fields we
+// use are included.
+//
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         json.Number    `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano json.Number    `json:"observedTimeUnixNano,omitempty"`
+	SeverityNumber       int            `json:"severityNumber,omitempty"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 *otlpAnyValue  `json:"body,omitempty"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+	TraceId              string         `json:"traceId,omitempty"`
+	SpanId               string         `json:"spanId,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string          `json:"stringValue,omitempty"`
+	BoolValue   *bool            `json:"boolValue,omitempty"`
+	IntValue    json.Number      `json:"intValue,omitempty"`
+	DoubleValue *float64         `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue  `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKvlistValue `json:"kvlistValue,omitempty"`
+	BytesValue  []byte           `json:"bytesValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKvlistValue struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// Receive logs exported over OTLP/HTTP by OpenTelemetry SDKs and collectors.
+//
+// Ex. `otlp://:4318` and set `OTEL_EXPORTER_OTLP_ENDPOINT=http://localhost:4318`.
+// Both the JSON and protobuf encodings are accepted. Like the logplex-source,
+// a user/password in the config URL requires basic auth and
+// `?cert=…&key=…` serves HTTPS.
+//
+// Each log record is sent on with its time and entries split out; see
+// otlpWrappedLine.
+func OTLPSource(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+	defer close(out)
+
+	err := serveHTTPSource(ctx, config, defaultOTLPAddress, makeOTLPHandler(config.User, out))
+	return query, err
+}
+
+// Create a handler accepting OTLP log exports, sending the records to `out`.
+func makeOTLPHandler(user *url.Userinfo, out chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != otlpLogsPath {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if user != nil && !logplexAuthorized(r, user) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="logmunch"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+
+		request, err := readOTLPRequest(r, isJSON)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, wrapped := range otlpWrappedLines(request) {
+			if err := sendLine(r.Context(), out, wrapped.String()); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		// An empty `ExportLogsServiceResponse` means everything was accepted
+		if isJSON {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{}"))
+		} else {
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+// Read an export request in either encoding
+func readOTLPRequest(r *http.Request, isJSON bool) (otlpLogsRequest, error) {
+	var request otlpLogsRequest
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return request, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxOTLPRequestSize+1))
+	if err != nil {
+		return request, err
+	} else if len(data) > maxOTLPRequestSize {
+		return request, errors.New("Export request too large")
+	}
+
+	if isJSON {
+		err = json.Unmarshal(data, &request)
+	} else {
+		err = decodeOTLPProtobuf(data, &request)
+	}
+	return request, err
+}
+
+// Split an export request into its log records
+func otlpWrappedLines(request otlpLogsRequest) []wrappedLine {
+	lines := []wrappedLine{}
+
+	for _, resourceLogs := range request.ResourceLogs {
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			for _, record := range scopeLogs.LogRecords {
+				lines = append(lines, otlpWrappedLine(resourceLogs.Resource, record))
+			}
+		}
+	}
+
+	return lines
+}
+
+// Decode a protobuf `ExportLogsServiceRequest` into the JSON structure.
+//
+//	message ExportLogsServiceRequest { repeated ResourceLogs resource_logs = 1; }
+//	message ResourceLogs { Resource resource = 1; repeated ScopeLogs scope_logs = 2; }
+//	message Resource { repeated KeyValue attributes = 1; }
+//	message ScopeLogs { InstrumentationScope scope = 1; repeated LogRecord log_records = 2; }
+//	message InstrumentationScope { string name = 1; string version = 2; }
+func decodeOTLPProtobuf(data []byte, request *otlpLogsRequest) error {
+	return walkProtobuf(data, func(field protobufField) error {
+		if field.num != 1 || field.typ != protowire.BytesType {
+			return nil
+		}
+
+		var resourceLogs otlpResourceLogs
+		err := walkProtobuf(field.bytes, func(field protobufField) error {
+			if field.typ != protowire.BytesType {
+				return nil
+			}
+
+			switch field.num {
+			case 1:
+				return walkProtobuf(field.bytes, func(field protobufField) error {
+					if field.num != 1 || field.typ != protowire.BytesType {
+						return nil
+					}
+					kv, err := decodeOTLPKeyValue(field.bytes)
+					resourceLogs.Resource.Attributes = append(resourceLogs.Resource.Attributes, kv)
+					return err
+				})
+			case 2:
+				scopeLogs, err := decodeOTLPScopeLogs(field.bytes)
+				resourceLogs.ScopeLogs = append(resourceLogs.ScopeLogs, scopeLogs)
+				return err
+			}
+			return nil
+		})
+
+		request.ResourceLogs = append(request.ResourceLogs, resourceLogs)
+		return err
+	})
+}
+
+func decodeOTLPScopeLogs(data []byte) (otlpScopeLogs, error) {
+	var scopeLogs otlpScopeLogs
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		if field.typ != protowire.BytesType {
+			return nil
+		}
+
+		switch field.num {
+		case 1:
+			return walkProtobuf(field.bytes, func(field protobufField) error {
+				switch field.num {
+				case 1:
+					scopeLogs.Scope.Name = string(field.bytes)
+				case 2:
+					scopeLogs.Scope.Version = string(field.bytes)
+				}
+				return nil
+			})
+		case 2:
+			record, err := decodeOTLPLogRecord(field.bytes)
+			scopeLogs.LogRecords = append(scopeLogs.LogRecords, record)
+			return err
+		}
+		return nil
+	})
+
+	return scopeLogs, err
+}
+
+// Decode a `LogRecord`:
+//
+//	fixed64 time_unix_nano = 1;
+//	SeverityNumber severity_number = 2;
+//	string severity_text = 3;
+//	AnyValue body = 5;
+//	repeated KeyValue attributes = 6;
+//	bytes trace_id = 9;
+//	bytes span_id = 10;
+//	fixed64 observed_time_unix_nano = 11;
+func decodeOTLPLogRecord(data []byte) (otlpLogRecord, error) {
+	var record otlpLogRecord
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		switch field.num {
+		case 1:
+			record.TimeUnixNano = json.Number(strconv.FormatUint(field.value, 10))
+		case 2:
+			record.SeverityNumber = int(field.value)
+		case 3:
+			record.SeverityText = string(field.bytes)
+		case 5:
+			body, err := decodeOTLPAnyValue(field.bytes)
+			record.Body = &body
+			return err
+		case 6:
+			kv, err := decodeOTLPKeyValue(field.bytes)
+			record.Attributes = append(record.Attributes, kv)
+			return err
+		case 9:
+			record.TraceId = hex.EncodeToString(field.bytes)
+		case 10:
+			record.SpanId = hex.EncodeToString(field.bytes)
+		case 11:
+			record.ObservedTimeUnixNano = json.Number(strconv.FormatUint(field.value, 10))
+		}
+		return nil
+	})
+
+	return record, err
+}
+
+// message KeyValue { string key = 1; AnyValue value = 2; }
+func decodeOTLPKeyValue(data []byte) (otlpKeyValue, error) {
+	var kv otlpKeyValue
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		switch field.num {
+		case 1:
+			kv.Key = string(field.bytes)
+		case 2:
+			value, err := decodeOTLPAnyValue(field.bytes)
+			kv.Value = value
+			return err
+		}
+		return nil
+	})
+
+	return kv, err
+}
+
+// Decode an `AnyValue`, where one of the following is set:
+//
+//	string string_value = 1;
+//	bool bool_value = 2;
+//	int64 int_value = 3;
+//	double double_value = 4;
+//	ArrayValue array_value = 5;     // repeated AnyValue values = 1;
+//	KeyValueList kvlist_value = 6;  // repeated KeyValue values = 1;
+//	bytes bytes_value = 7;
+func decodeOTLPAnyValue(data []byte) (otlpAnyValue, error) {
+	var value otlpAnyValue
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		switch field.num {
+		case 1:
+			s := string(field.bytes)
+			value.StringValue = &s
+		case 2:
+			b := field.value != 0
+			value.BoolValue = &b
+		case 3:
+			value.IntValue = json.Number(strconv.FormatInt(int64(field.value), 10))
+		case 4:
+			f := math.Float64frombits(field.value)
+			value.DoubleValue = &f
+		case 5:
+			value.ArrayValue = &otlpArrayValue{Values: []otlpAnyValue{}}
+			return walkProtobuf(field.bytes, func(field protobufField) error {
+				item, err := decodeOTLPAnyValue(field.bytes)
+				value.ArrayValue.Values = append(value.ArrayValue.Values, item)
+				return err
+			})
+		case 6:
+			value.KvlistValue = &otlpKvlistValue{Values: []otlpKeyValue{}}
+			return walkProtobuf(field.bytes, func(field protobufField) error {
+				kv, err := decodeOTLPKeyValue(field.bytes)
+				value.KvlistValue.Values = append(value.KvlistValue.Values, kv)
+				return err
+			})
+		case 7:
+			value.BytesValue = field.bytes
+		}
+		return nil
+	})
+
+	return value, err
+}
+
+// Convert a value to what `encoding/json` would give (with `UseNumber()`, so
+// 64-bit integers are kept as written), so it can be flattened like JSON.
+func (v otlpAnyValue) toInterface() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != "":
+		return v.IntValue
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		values := make([]interface{}, len(v.ArrayValue.Values))
+		for i, item := range v.ArrayValue.Values {
+			values[i] = item.toInterface()
+		}
+		return values
+	case v.KvlistValue != nil:
+		return otlpAttributesToMap(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		return hex.EncodeToString(v.BytesValue)
+	}
+	return nil
+}
+
+func otlpAttributesToMap(attributes []otlpKeyValue) map[string]interface{} {
+	values := make(map[string]interface{}, len(attributes))
+	for _, kv := range attributes {
+		values[kv.Key] = kv.Value.toInterface()
+	}
+	return values
+}
+
+// Names for the ranges of `SeverityNumber`
+var otlpSeverityNames = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+func otlpSeverity(record otlpLogRecord) string {
+	if record.SeverityText != "" {
+		return record.SeverityText
+	}
+
+	if record.SeverityNumber >= 1 && record.SeverityNumber <= 24 {
+		return otlpSeverityNames[(record.SeverityNumber-1)/4]
+	}
+	return ""
+}
+
+// Split out the time and entries of a log record.
+//
+// Resource attributes go into the entries prefixed with `resource.` and the
+// record's attributes as they are, both flattened like JSON. The severity
+// goes into `level` and the body into `message`; if the body is logfmt or
+// JSON, the keys in it are added too. The name is the `service.name`.
+func otlpWrappedLine(resource otlpResource, record otlpLogRecord) wrappedLine {
+	logLine := LogLine{Entries: make(map[string]string)}
+
+	for _, nanos := range []json.Number{record.TimeUnixNano, record.ObservedTimeUnixNano} {
+		if ns, err := strconv.ParseUint(nanos.String(), 10, 64); err == nil && ns != 0 {
+			logLine.Time = time.Unix(0, int64(ns)).UTC()
+			break
+		}
+	}
+
+	resourceAttributes := otlpAttributesToMap(resource.Attributes)
+	if name, ok := resourceAttributes["service.name"].(string); ok {
+		logLine.Name = name
+	}
+	flattenAndStringifyJSON("resource", resourceAttributes, &logLine)
+	flattenAndStringifyJSON("", otlpAttributesToMap(record.Attributes), &logLine)
+
+	if level := otlpSeverity(record); level != "" {
+		logLine.Entries["level"] = level
+	}
+	if record.TraceId != "" {
+		logLine.Entries["trace_id"] = record.TraceId
+	}
+	if record.SpanId != "" {
+		logLine.Entries["span_id"] = record.SpanId
+	}
+
+	// Pick up any keys in a message, but keep our name
+	wrapped := wrappedLine{KeepName: true}
+
+	if record.Body != nil {
+		switch body := record.Body.toInterface().(type) {
+		case map[string]interface{}:
+			flattenAndStringifyJSON("", body, &logLine)
+		case string:
+			logLine.Entries["message"] = body
+			wrapped.Message = body
+		case nil:
+		default:
+			flattenAndStringifyJSON("", map[string]interface{}{"message": body}, &logLine)
+		}
+	}
+
+	wrapped.Time = logLine.Time
+	wrapped.Name = logLine.Name
+	wrapped.Entries = logLine.Entries
+	return wrapped
+}
@@ -0,0 +1,202 @@
+package logmunch
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func postOTLP(handler http.Handler, contentType string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", otlpLogsPath, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func parseOTLPOutput(t *testing.T, out chan string) []LogLine {
+	close(out)
+
+	lines := []LogLine{}
+	for line := range out {
+		logLine, err := parseLogLine(line)
+		if err != nil {
+			t.Fatalf("Could not parse `%s`: %s", line, err)
+		}
+		lines = append(lines, logLine)
+	}
+	return lines
+}
+
+func TestOTLPHandlerJSON(t *testing.T) {
+	out := make(chan string, 10)
+	handler := makeOTLPHandler(nil, out)
+
+	w := postOTLP(handler, "application/json", `{"resourceLogs": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "api"}},
+			{"key": "host", "value": {"kvlistValue": {"values": [{"key": "cpus", "value": {"intValue": "4"}}]}}}
+		]},
+		"scopeLogs": [{
+			"scope": {"name": "app"},
+			"logRecords": [
+				{
+					"timeUnixNano": "1546398245123456789",
+					"severityNumber": 9,
+					"body": {"stringValue": "request done status=200"},
+					"attributes": [
+						{"key": "http.method", "value": {"stringValue": "GET"}},
+						{"key": "request.id", "value": {"intValue": "9007199254740993"}}
+					],
+					"traceId": "5b8efff798038103d269b633813fc60c"
+				},
+				{
+					"observedTimeUnixNano": 1546398246000000000,
+					"severityText": "WARN",
+					"body": {"kvlistValue": {"values": [{"key": "retry", "value": {"boolValue": true}}]}}
+				}
+			]
+		}]
+	}]}`)
+
+	if w.Code != http.StatusOK || w.Body.String() != "{}" {
+		t.Fatalf("Expected HTTP 200 with `{}`, got %d: %s", w.Code, w.Body)
+	}
+
+	expected := []LogLine{
+		{
+			Time: time.Date(2019, 1, 2, 3, 4, 5, 123456789, time.UTC),
+			Name: "api",
+			Entries: map[string]string{
+				"resource.service.name": "api",
+				"resource.host.cpus":    "4",
+				"http.method":           "GET",
+				"request.id":            "9007199254740993",
+				"level":                 "info",
+				"trace_id":              "5b8efff798038103d269b633813fc60c",
+				"message":               "request done status=200",
+				"status":                "200",
+			},
+		},
+		{
+			Time: time.Date(2019, 1, 2, 3, 4, 6, 0, time.UTC),
+			Name: "api",
+			Entries: map[string]string{
+				"resource.service.name": "api",
+				"resource.host.cpus":    "4",
+				"level":                 "WARN",
+				"retry":                 "true",
+			},
+		},
+	}
+
+	lines := parseOTLPOutput(t, out)
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(lines))
+	}
+
+	for i := range expected {
+		if !lines[i].Equal(expected[i]) {
+			t.Errorf("Expected\n\t`%s`\ngot\n\t`%s`", expected[i].String(), lines[i].String())
+		}
+	}
+}
+
+// Append a KeyValue as field `num`
+func appendOTLPKeyValue(b []byte, num protowire.Number, key string, value []byte) []byte {
+	var kv []byte
+	kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+	kv = protowire.AppendString(kv, key)
+	kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+	kv = protowire.AppendBytes(kv, value)
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, kv)
+}
+
+func TestOTLPHandlerProtobuf(t *testing.T) {
+	var stringValue []byte
+	stringValue = protowire.AppendTag(stringValue, 1, protowire.BytesType)
+	stringValue = protowire.AppendString(stringValue, "worker")
+
+	var doubleValue []byte
+	doubleValue = protowire.AppendTag(doubleValue, 4, protowire.Fixed64Type)
+	doubleValue = protowire.AppendFixed64(doubleValue, math.Float64bits(0.25))
+
+	var body []byte
+	body = protowire.AppendTag(body, 1, protowire.BytesType)
+	body = protowire.AppendString(body, `{"num": 123}`)
+
+	var record []byte
+	record = protowire.AppendTag(record, 1, protowire.Fixed64Type)
+	record = protowire.AppendFixed64(record, 1546398245000005000)
+	record = protowire.AppendTag(record, 2, protowire.VarintType)
+	record = protowire.AppendVarint(record, 17)
+	record = protowire.AppendTag(record, 5, protowire.BytesType)
+	record = protowire.AppendBytes(record, body)
+	record = appendOTLPKeyValue(record, 6, "load", doubleValue)
+	record = protowire.AppendTag(record, 10, protowire.BytesType)
+	record = protowire.AppendBytes(record, []byte{0xab, 0xcd})
+
+	var scopeLogs []byte
+	scopeLogs = protowire.AppendTag(scopeLogs, 2, protowire.BytesType)
+	scopeLogs = protowire.AppendBytes(scopeLogs, record)
+
+	var resource []byte
+	resource = appendOTLPKeyValue(resource, 1, "service.name", stringValue)
+
+	var resourceLogs []byte
+	resourceLogs = protowire.AppendTag(resourceLogs, 1, protowire.BytesType)
+	resourceLogs = protowire.AppendBytes(resourceLogs, resource)
+	resourceLogs = protowire.AppendTag(resourceLogs, 2, protowire.BytesType)
+	resourceLogs = protowire.AppendBytes(resourceLogs, scopeLogs)
+
+	var request []byte
+	request = protowire.AppendTag(request, 1, protowire.BytesType)
+	request = protowire.AppendBytes(request, resourceLogs)
+
+	out := make(chan string, 10)
+	handler := makeOTLPHandler(nil, out)
+
+	w := postOTLP(handler, "application/x-protobuf", string(request))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected HTTP 200, got %d: %s", w.Code, w.Body)
+	}
+
+	expected := LogLine{
+		Time: time.Date(2019, 1, 2, 3, 4, 5, 5000, time.UTC),
+		Name: "worker",
+		Entries: map[string]string{
+			"resource.service.name": "worker",
+			"load":                  "0.25",
+			"level":                 "error",
+			"span_id":               "abcd",
+			"message":               `{"num": 123}`,
+			"num":                   "123",
+		},
+	}
+
+	lines := parseOTLPOutput(t, out)
+	if len(lines) != 1 || !lines[0].Equal(expected) {
+		t.Errorf("Expected\n\t%v\ngot\n\t%v", []LogLine{expected}, lines)
+	}
+}
+
+func TestOTLPHandlerErrors(t *testing.T) {
+	out := make(chan string, 10)
+	handler := makeOTLPHandler(nil, out)
+
+	if w := postOTLP(handler, "application/json", `{"resourceLogs": 42}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected HTTP 400 for bad JSON, got %d", w.Code)
+	}
+
+	if w := postOTLP(handler, "application/x-protobuf", "\xff\xff"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected HTTP 400 for bad protobuf, got %d", w.Code)
+	}
+}
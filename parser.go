@@ -1,7 +1,9 @@
 package logmunch
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -40,6 +42,8 @@ func flattenAndStringifyJSON(prefix string, data map[string]interface{}, log *Lo
 			}
 		case float64:
 			log.SetNumber(key, t)
+		case json.Number:
+			log.Entries[key] = t.String()
 		case string:
 			log.Entries[key] = t
 		case map[string]interface{}:
@@ -163,115 +167,474 @@ func tryTicEscapedLogFmt(line string, log *LogLine) bool {
 	return tryPrefixedLogFmt(line, log)
 }
 
-func ParseLogEntries(in <-chan string, out chan<- LogLine) {
-	defer close(out)
-	for line := range in {
-		// Skip empty lines
-		if line == "" {
-			continue
+var (
+	errEmptyLine   = errors.New("Empty line")
+	errNoTimestamp = errors.New("Could not find timestamp")
+)
+
+// Turns raw lines into LogLines. The zero value auto-detects the format.
+type LogParser struct {
+	// How to parse what's left of lines after the timestamp etc.; nil
+	// means the `auto` format.
+	Format LineParser
+
+	// Go layout for timestamps, tried before the built-in ones. It may
+	// contain spaces, and the timestamp may be wrapped in `[…]`.
+	TimeLayout string
+
+	// Take the time from this key rather than the start of the line
+	TimeKey string
+
+	// Where timestamps without a zone are from; nil means UTC
+	Location *time.Location
+
+	// What ParseLogEntries does with lines without a timestamp
+	NoTime NoTimePolicy
+
+	// How ParseLogEntries joins lines into multi-line events
+	Multiline Multiline
+}
+
+// What to do with lines without a timestamp
+type NoTimePolicy int
+
+const (
+	// Skip them, with a warning on stderr
+	NoTimeDrop NoTimePolicy = iota
+
+	// Use the time of the line before it, i.e. for stack traces. Lines
+	// before the first one with a time are handled as with NoTimeZero.
+	NoTimeInherit
+
+	// Use the time the line is parsed
+	NoTimeIngest
+
+	// Use the zero time and add `_no_time=true`
+	NoTimeZero
+)
+
+var noTimePolicies = map[string]NoTimePolicy{
+	"drop":    NoTimeDrop,
+	"inherit": NoTimeInherit,
+	"ingest":  NoTimeIngest,
+	"zero":    NoTimeZero,
+}
+
+// Get a NoTimePolicy by name; `drop`, `inherit`, `ingest` or `zero`.
+func ParseNoTimePolicy(name string) (NoTimePolicy, error) {
+	policy, found := noTimePolicies[name]
+	if !found {
+		return NoTimeDrop, fmt.Errorf("Unknown policy '%s' for lines without a timestamp (use drop, inherit, ingest or zero).", name)
+	}
+	return policy, nil
+}
+
+// Parse a single raw line into a LogLine, auto-detecting its format
+func parseLogLine(line string) (LogLine, error) {
+	return LogParser{}.ParseLine(line)
+}
+
+// Parse a single raw line into a LogLine
+func (p LogParser) ParseLine(line string) (LogLine, error) {
+	// Multi-line events are parsed by their first line with the rest kept
+	// in `continuation`, unless it is JSON spread over several lines.
+	first, rest, multiline := strings.Cut(line, "\n")
+	continued := multiline && jsonDepth(first) <= 0
+	if continued {
+		line = first
+	}
+
+	logLine, err := p.parseLine(line)
+	if err != nil {
+		return logLine, err
+	}
+
+	if continued {
+		logLine.Entries["continuation"] = rest
+	}
+
+	if value, found := logLine.Entries[p.TimeKey]; found && p.TimeKey != "" {
+		if when, ok := p.parseTime(value, true); ok {
+			logLine.Time = when
+			delete(logLine.Entries, p.TimeKey)
 		}
+	}
+
+	if logLine.Time.IsZero() {
+		return logLine, errNoTimestamp
+	}
 
-		logLine := LogLine{
-			Entries: make(map[string]string),
+	return logLine, nil
+}
+
+func (p LogParser) parseLine(line string) (LogLine, error) {
+	format := p.Format
+	if format == nil {
+		format = autoParser{}
+	}
+
+	// Sources that split out the time and entries themselves
+	if strings.HasPrefix(line, wrappedLinePrefix) {
+		var wrapped wrappedLine
+		if err := json.Unmarshal([]byte(line[len(wrappedLinePrefix):]), &wrapped); err == nil {
+			return p.parseWrapped(wrapped, format)
 		}
+	}
 
-		// Some log-lines from Heroku has a leading `d `, which I can't figure out.
-		// So out it goes
-		if len(line) >= 2 && line[0] == 'd' && line[1] == ' ' {
-			line = line[2:]
+	// Only guess at lines wrapped by others when not told what the lines are
+	if _, auto := format.(autoParser); auto {
+		if wrapped, ok := unwrapLine(line); ok {
+			return p.parseWrapped(wrapped, format)
 		}
+	}
 
-		// OFFSET ID TIMESTAMP LINE
-		// but also
-		// TIMESTAMP LINE
-		lineParts := strings.Fields(line)
+	logLine, body, err := p.parseLineHeader(line)
+	if err != nil {
+		return logLine, err
+	}
 
-		if len(lineParts) < 1 {
-			continue
+	// Formats with the time in the middle of the line find it themselves
+	parseLineBody(body, &logLine, format)
+	return logLine, nil
+}
+
+// Sources that get lines with the time and some entries already split out
+// (ex. from a container runtime or a Loki push) send them on as this
+// prefix followed by a wrappedLine in JSON, like JSON text sequences (RFC
+// 7464) do.
+const wrappedLinePrefix = "\x1e"
+
+// A message that came with its time and some entries. These are unwrapped
+// whatever the format, which then only applies to the message.
+type wrappedLine struct {
+	Time    time.Time         `json:"time"`
+	Name    string            `json:"name,omitempty"`
+	Entries map[string]string `json:"entries,omitempty"`
+
+	// Parsed like a line of its own, except for the time; the entries found
+	// in it take precedence. Without a Name, the message's name is used.
+	// With one, the message's name is added to it, or if the message has
+	// no entries, it is kept in `message`.
+	Message string `json:"message,omitempty"`
+
+	// Use Name as it is, whatever the message
+	KeepName bool `json:"keep_name,omitempty"`
+}
+
+// Format it for sending on from a source
+func (w wrappedLine) String() string {
+	data, _ := json.Marshal(w)
+	return wrappedLinePrefix + string(data)
+}
+
+// Unwrap lines written by container runtimes or `journalctl -o json` and
+// read with other sources than their own. Returns false if the line isn't
+// wrapped.
+func unwrapLine(line string) (wrappedLine, bool) {
+	if line == "" {
+		return wrappedLine{}, false
+	}
+
+	// CRI lines start with the time; the others are JSON
+	switch {
+	case line[0] >= '0' && line[0] <= '9':
+		if entry, ok := parseCRILine(line); ok {
+			return entry.wrapped(), true
 		}
+	case strings.HasPrefix(line, `{"log":`):
+		if entry, ok := parseDockerLine(line); ok {
+			return entry.wrapped(), true
+		}
+	case line[0] == '{':
+		return unwrapJournalLine(line)
+	}
 
-		// Remove the first element if equal line length
-		// https://tools.ietf.org/html/rfc6587#section-3.4.1
-		// LOG := LEN(LINE) + LINE
-		length, err := strconv.ParseInt(lineParts[0], 10, 32)
-		if err == nil && int(length) == (len(line)-len(lineParts[0])) {
-			lineParts = lineParts[1:]
+	return wrappedLine{}, false
+}
+
+// Turn a wrappedLine into a LogLine, parsing its message with `format`.
+func (p LogParser) parseWrapped(wrapped wrappedLine, format LineParser) (LogLine, error) {
+	logLine := LogLine{
+		Time:    wrapped.Time,
+		Name:    wrapped.Name,
+		Entries: make(map[string]string, len(wrapped.Entries)),
+	}
+	for key, value := range wrapped.Entries {
+		logLine.Entries[key] = value
+	}
+
+	if wrapped.Message == "" {
+		if wrapped.Name == "" && !wrapped.KeepName {
+			return logLine, errEmptyLine
 		}
+		return logLine, nil
+	}
 
-		// Parse out PRIVAL
-		// https://tools.ietf.org/html/rfc5424#section-6.2.1
-		// < + (facility << 3) + severity + > + SYSLOG_VERSION
-		if strings.HasPrefix(lineParts[0], "<") && strings.IndexRune(lineParts[0], '>') != -1 {
-			prival, err := strconv.ParseInt(lineParts[0][1:strings.IndexRune(lineParts[0], '>')], 10, 32)
-			if err == nil {
-				logLine.Entries["syslog.severity"] = fmt.Sprintf("%d", prival&0x7)
-				logLine.Entries["syslog.facility"] = fmt.Sprintf("%d", prival>>3)
+	// The message may have a syslog PRIVAL or a time of its own
+	message, body, err := p.parseLineHeader(wrapped.Message)
+	if err != nil && wrapped.Name == "" && !wrapped.KeepName {
+		return logLine, err
+	}
+	parseLineBody(body, &message, format)
+
+	switch {
+	case wrapped.KeepName:
+	case wrapped.Name == "":
+		logLine.Name = message.Name
+	case len(message.Entries) == 0:
+		logLine.Entries["message"] = wrapped.Message
+	default:
+		logLine.Name = strings.TrimSpace(wrapped.Name + " " + message.Name)
+	}
 
-				lineParts = lineParts[1:]
-			}
+	for key, value := range message.Entries {
+		logLine.Entries[key] = value
+	}
+
+	return logLine, nil
+}
+
+// Parse a timestamp with the configured layout or the built-in ones, and
+// optionally as a Unix timestamp.
+func (p LogParser) parseTime(value string, epochs bool) (time.Time, bool) {
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	if p.TimeLayout == UnixTimeFormat {
+		if when, ok := parseEpoch(value); ok {
+			return when, true
 		}
+	} else if p.TimeLayout != "" {
+		if when, err := time.ParseInLocation(p.TimeLayout, strings.Trim(value, "[]"), loc); err == nil {
+			return when, true
+		}
+	}
 
-		// Try parsing each element in the line as various timestamps and see
-		// what sticks.
-		for i, part := range lineParts {
-			// Seen in front-end logging system: `timestamp='TIMESTAMP'` if it starts with that - strip it
-			if strings.HasPrefix(part, "timestamp='") {
-				part = part[11 : len(part)-1] // Strip `timestamp='` and trailing `'`
-			}
+	for _, timefmt := range timeformats {
+		if when, err := time.ParseInLocation(timefmt, value, loc); err == nil {
+			return when, true
+		}
+	}
 
-			for _, timefmt := range timeformats {
-				lineTime, err := time.Parse(timefmt, part)
-
-				if err == nil {
-					logLine.Time = lineTime
-					newLine := make([]string, len(lineParts)-1)
-					copy(newLine[:i], lineParts[:i])
-					copy(newLine[i:], lineParts[i+1:])
-					lineParts = newLine
-					break
-				}
-			}
+	if epochs {
+		return parseEpoch(value)
+	}
+	return time.Time{}, false
+}
+
+// Look for a timestamp starting at `lineParts[i]`, optionally as a Unix
+// timestamp, returning it and how many parts it spans.
+func (p LogParser) findTime(lineParts []string, i int, epochs bool) (time.Time, int) {
+	part := lineParts[i]
+
+	// The configured layout can span several parts, ex. `2006-01-02 15:04:05`
+	if n := len(strings.Fields(p.TimeLayout)); n > 1 && i+n <= len(lineParts) {
+		if when, ok := p.parseTime(strings.Join(lineParts[i:i+n], " "), false); ok {
+			return when, n
 		}
+	}
 
-		if logLine.Time.IsZero() {
-			fmt.Fprintf(os.Stderr, "Could not find timestamp in line `%s`.\n", line)
-			continue
+	// Seen in front-end logging system: `timestamp='TIMESTAMP'` if it starts with that - strip it
+	if strings.HasPrefix(part, "timestamp='") {
+		part = part[11 : len(part)-1] // Strip `timestamp='` and trailing `'`
+	}
+
+	if when, ok := p.parseTime(part, epochs); ok {
+		return when, 1
+	}
+
+	return time.Time{}, 0
+}
+
+// What's left of a line after the header
+type lineBody struct {
+	// With runs of whitespace made single spaces
+	text string
+
+	// With the whitespace as written, for rawLineParsers
+	raw string
+}
+
+// Parse out the framing, syslog PRIVAL and timestamp from a line, returning
+// the rest of it.
+func (p LogParser) parseLineHeader(line string) (LogLine, lineBody, error) {
+	logLine := LogLine{
+		Entries: make(map[string]string),
+	}
+
+	// Skip empty lines
+	if line == "" {
+		return logLine, lineBody{}, errEmptyLine
+	}
+
+	// Some log-lines from Heroku has a leading `d `, which I can't figure out.
+	// So out it goes
+	if len(line) >= 2 && line[0] == 'd' && line[1] == ' ' {
+		line = line[2:]
+	}
+
+	// OFFSET ID TIMESTAMP LINE
+	// but also
+	// TIMESTAMP LINE
+	lineParts, spans := fieldSpans(line)
+
+	if len(lineParts) < 1 {
+		return logLine, lineBody{}, errEmptyLine
+	}
+
+	// Remove the first element if equal line length
+	// https://tools.ietf.org/html/rfc6587#section-3.4.1
+	// LOG := LEN(LINE) + LINE
+	length, err := strconv.ParseInt(lineParts[0], 10, 32)
+	if err == nil && int(length) == (len(line)-len(lineParts[0])) {
+		lineParts, spans = lineParts[1:], spans[1:]
+	}
+
+	// Parse out PRIVAL
+	// https://tools.ietf.org/html/rfc5424#section-6.2.1
+	// < + (facility << 3) + severity + > + SYSLOG_VERSION
+	if len(lineParts) > 0 && strings.HasPrefix(lineParts[0], "<") && strings.IndexRune(lineParts[0], '>') != -1 {
+		prival, err := strconv.ParseInt(lineParts[0][1:strings.IndexRune(lineParts[0], '>')], 10, 32)
+		if err == nil {
+			logLine.Entries["syslog.severity"] = fmt.Sprintf("%d", prival&0x7)
+			logLine.Entries["syslog.facility"] = fmt.Sprintf("%d", prival>>3)
+
+			lineParts, spans = lineParts[1:], spans[1:]
 		}
+	}
 
-		restOfLine := strings.Join(lineParts, " ")
+	if len(lineParts) == 0 {
+		return logLine, lineBody{}, nil
+	}
 
-		// The somewhat popular `NAME {… JSON …}`
-		if ok := tryParseOutJSON(restOfLine, &logLine); ok {
-			out <- logLine
-			continue
+	// Try parsing each element in the line as various timestamps and see
+	// what sticks. Only the first part can be a unix timestamp, and only if
+	// there's no other, lest we mistake ids and numbers for one.
+	for i := range lineParts {
+		when, n := p.findTime(lineParts, i, false)
+		if n == 0 && i == len(lineParts)-1 {
+			i = 0
+			when, n = p.findTime(lineParts, i, true)
 		}
 
-		// Heroku's `d.UUID NAME - - key=val key=val …` format.
-		if ok := tryHerokuLogFmt(restOfLine, &logLine); ok {
-			out <- logLine
-			continue
+		if n > 0 {
+			logLine.Time = when
+
+			// Cut it out, keeping what's around it as written
+			before := strings.TrimRightFunc(line[spans[0][0]:spans[i][0]], unicode.IsSpace)
+			after := strings.TrimSpace(line[spans[i+n-1][1]:])
+			raw := before + after
+			if before != "" && after != "" {
+				raw = before + " " + after
+			}
+
+			lineParts = append(lineParts[:i:i], lineParts[i+n:]...)
+			return logLine, lineBody{text: strings.Join(lineParts, " "), raw: raw}, nil
 		}
+	}
 
-		// Logentries serialize with a='b' (not a="b")
-		if ok := tryTicEscapedLogFmt(restOfLine, &logLine); ok {
-			out <- logLine
-			continue
+	return logLine, lineBody{
+		text: strings.Join(lineParts, " "),
+		raw:  strings.TrimSpace(line[spans[0][0]:]),
+	}, nil
+}
+
+// Split a line on whitespace like strings.Fields, along with where each
+// part starts and ends in the line.
+func fieldSpans(line string) ([]string, [][2]int) {
+	var parts []string
+	var spans [][2]int
+
+	start := -1
+	for i, r := range line {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				parts = append(parts, line[start:i])
+				spans = append(spans, [2]int{start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
 		}
+	}
+	if start >= 0 {
+		parts = append(parts, line[start:])
+		spans = append(spans, [2]int{start, len(line)})
+	}
 
-		// Some prefix text and=then some=logfmt
-		if ok := tryPrefixedLogFmt(restOfLine, &logLine); ok {
-			out <- logLine
-			continue
+	return parts, spans
+}
+
+// Parse the name and key/values from what's left of a line after the header
+func parseLineBody(body lineBody, logLine *LogLine, format LineParser) {
+	if ok := parseBodyWith(format, body, logLine); ok {
+		return
+	}
+
+	// Really really give up.
+	logLine.Name = body.text
+}
+
+// Parse the raw lines on `in` and put them on `out`, auto-detecting their
+// format.
+// Note: Closes `out` when `in` does so, or the context is cancelled.
+func ParseLogEntries(ctx context.Context, in <-chan string, out chan<- LogLine) {
+	LogParser{}.ParseLogEntries(ctx, in, out)
+}
+
+// Parse the raw lines on `in` and put them on `out`.
+// Note: Closes `out` when `in` does so, or the context is cancelled.
+func (p LogParser) ParseLogEntries(ctx context.Context, in <-chan string, out chan<- LogLine) {
+	defer close(out)
+
+	if p.Multiline.Enabled() {
+		joined := make(chan string, 100)
+		go p.Multiline.JoinLines(ctx, in, joined)
+		in = joined
+	}
+
+	// The time of the last line that had one
+	var previous time.Time
+
+	for {
+		var line string
+		var ok bool
+
+		select {
+		case line, ok = <-in:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
 		}
 
-		// Give up. ` SOMETHING - - MESSAGE GOES HERE`
-		if ok := tryPlainMessage(restOfLine, &logLine); ok {
-			out <- logLine
+		logLine, err := p.ParseLine(line)
+
+		if err == errNoTimestamp {
+			switch {
+			case p.NoTime == NoTimeInherit && !previous.IsZero():
+				logLine.Time = previous
+			case p.NoTime == NoTimeIngest:
+				logLine.Time = time.Now()
+			case p.NoTime == NoTimeZero || p.NoTime == NoTimeInherit:
+				logLine.Entries["_no_time"] = "true"
+			default:
+				fmt.Fprintf(os.Stderr, "Could not find timestamp in line `%s`.\n", line)
+				continue
+			}
+		} else if err != nil {
 			continue
+		} else {
+			previous = logLine.Time
 		}
 
-		// Really really give up.
-		logLine.Name = restOfLine
-		out <- logLine
+		select {
+		case out <- logLine:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
@@ -1,6 +1,7 @@
 package logmunch
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -35,6 +36,24 @@ func TestParseLines(t *testing.T) {
 			},
 		},
 
+		// Runs of whitespace are single spaces to the formats
+		{
+			in: "2015-06-12T00:11:22Z INFO  worker  job=7",
+			out: LogLine{
+				Time:    time.Date(2015, 6, 12, 0, 11, 22, 0, time.UTC),
+				Name:    "INFO worker",
+				Entries: map[string]string{"job": "7"},
+			},
+		},
+		{
+			in: "2015-06-12T00:11:22Z app\t-\t-\tat=info method=GET status=200",
+			out: LogLine{
+				Time:    time.Date(2015, 6, 12, 0, 11, 22, 0, time.UTC),
+				Name:    "app",
+				Entries: map[string]string{"at": "info", "method": "GET", "status": "200"},
+			},
+		},
+
 		// Heroku runtime output
 		{
 			in: `296 <158>1 2015-03-20T19:22:56.023454+00:00 d.f12ee345-3239-4fde-8dc6-b5d1c5656c36 heroku router - - at=info method=POST path="/v1/oauth/token" host=api.g2m.me request_id=4ce69d2b-fd28-44f0-809c-05e192a0b2e0 fwd="54.160.189.106,173.245.56.103" dyno=web.2 connect=1ms service=4ms status=200 bytes=455`,
@@ -171,13 +190,40 @@ func TestParseLines(t *testing.T) {
 				},
 			},
 		},
+
+		// Docker json-file; the wrapper's time wins over the inner one
+		{
+			in: `{"log":"2015-06-12T00:11:22.333Z api at=info status=200\n","stream":"stdout","time":"2019-01-02T03:04:05.123456789Z"}`,
+			out: LogLine{
+				Time: time.Date(2019, 1, 2, 3, 4, 5, 123456789, time.UTC),
+				Name: "api",
+				Entries: map[string]string{
+					"at":     "info",
+					"status": "200",
+					"stream": "stdout",
+				},
+			},
+		},
+
+		// Kubernetes CRI
+		{
+			in: `2019-01-02T03:04:05.123456789Z stderr F worker {"num": 123}`,
+			out: LogLine{
+				Time: time.Date(2019, 1, 2, 3, 4, 5, 123456789, time.UTC),
+				Name: "worker",
+				Entries: map[string]string{
+					"num":    "123",
+					"stream": "stderr",
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		in := make(chan string, 1)
 		out := make(chan LogLine)
 
-		go ParseLogEntries(in, out)
+		go ParseLogEntries(context.Background(), in, out)
 
 		in <- tt.in
 		close(in)
@@ -205,7 +251,7 @@ func TestParseInvalidLines(t *testing.T) {
 		in := make(chan string)
 		out := make(chan LogLine)
 
-		go ParseLogEntries(in, out)
+		go ParseLogEntries(context.Background(), in, out)
 
 		in <- tt
 		close(in)
@@ -216,3 +262,53 @@ func TestParseInvalidLines(t *testing.T) {
 	}
 
 }
+
+func TestParseLogEntriesNoTime(t *testing.T) {
+	lines := []string{
+		"worker starting",
+		"2015-06-12T00:11:22.333Z worker job=1",
+		"\tat Worker.run(Worker.java:42)",
+	}
+	first := time.Date(2015, 6, 12, 0, 11, 22, 333000000, time.UTC)
+
+	var tests = []struct {
+		policy string
+		times  []time.Time
+		noTime []string
+	}{
+		{"drop", []time.Time{first}, []string{""}},
+		{"inherit", []time.Time{{}, first, first}, []string{"true", "", ""}},
+		{"zero", []time.Time{{}, first, {}}, []string{"true", "", "true"}},
+	}
+
+	for _, tt := range tests {
+		policy, err := ParseNoTimePolicy(tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		in := make(chan string, len(lines))
+		out := make(chan LogLine)
+		for _, line := range lines {
+			in <- line
+		}
+		close(in)
+
+		go LogParser{NoTime: policy}.ParseLogEntries(context.Background(), in, out)
+
+		i := 0
+		for log := range out {
+			if i < len(tt.times) && (!log.Time.Equal(tt.times[i]) || log.Entries["_no_time"] != tt.noTime[i]) {
+				t.Errorf("-no-time=%s: Expected line %d at %s (_no_time=%s), got `%s`", tt.policy, i, tt.times[i], tt.noTime[i], log)
+			}
+			i++
+		}
+		if i != len(tt.times) {
+			t.Errorf("-no-time=%s: Expected %d lines, got %d", tt.policy, len(tt.times), i)
+		}
+	}
+
+	if _, err := ParseNoTimePolicy("guess"); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
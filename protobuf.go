@@ -0,0 +1,68 @@
+package logmunch
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A field of a protobuf message. Length-delimited fields (strings, bytes and
+// embedded messages) have their data in `bytes`; numbers are in `value`.
+type protobufField struct {
+	num   protowire.Number
+	typ   protowire.Type
+	bytes []byte
+	value uint64
+}
+
+// Call `f` with each field of a protobuf message, in the order they appear.
+func walkProtobuf(data []byte, f func(field protobufField) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		field := protobufField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			field.value, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			var value uint32
+			value, n = protowire.ConsumeFixed32(data)
+			field.value = uint64(value)
+		case protowire.Fixed64Type:
+			field.value, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := f(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decode a `google.protobuf.Timestamp`; `seconds = 1` and `nanos = 2`.
+func decodeProtobufTimestamp(data []byte) time.Time {
+	var seconds, nanos uint64
+	walkProtobuf(data, func(field protobufField) error {
+		switch field.num {
+		case 1:
+			seconds = field.value
+		case 2:
+			nanos = field.value
+		}
+		return nil
+	})
+	return time.Unix(int64(seconds), int64(nanos)).UTC()
+}
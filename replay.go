@@ -0,0 +1,72 @@
+package logmunch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse a replay speed like `10x`, `0.5x` or `2`.
+func ParseReplaySpeed(value string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("Cannot parse replay speed '%s' (ex. 1x, 10x or 0.5x).", value)
+	}
+	return speed, nil
+}
+
+// Pass lines from `in` to `out` paced by the time between them, as if they
+// were happening right now; `speed` 10 plays them ten times as fast.
+// Lines going back in time are passed on right away.
+//
+// With `rewriteTime`, each line gets the time it was passed on as its time.
+func ReplayLogChan(ctx context.Context, speed float64, rewriteTime bool, in <-chan LogLine, out chan<- LogLine) {
+	defer close(out)
+
+	var firstLine time.Time
+	var started time.Time
+	timer := time.NewTimer(0)
+	<-timer.C
+
+	for {
+		var l LogLine
+		var ok bool
+
+		select {
+		case l, ok = <-in:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		if started.IsZero() {
+			firstLine = l.Time
+			started = time.Now()
+		}
+
+		delay := time.Until(started.Add(time.Duration(float64(l.Time.Sub(firstLine)) / speed)))
+		if delay > 0 {
+			timer.Reset(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		if rewriteTime {
+			l.Time = time.Now()
+		}
+
+		select {
+		case out <- l:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package logmunch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseReplaySpeed(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out float64
+		err bool
+	}{
+		{in: "10x", out: 10},
+		{in: "0.5x", out: 0.5},
+		{in: "2", out: 2},
+		{in: "0x", err: true},
+		{in: "-1x", err: true},
+		{in: "fast", err: true},
+	}
+
+	for _, tt := range tests {
+		out, err := ParseReplaySpeed(tt.in)
+		if (err != nil) != tt.err || out != tt.out {
+			t.Errorf("ParseReplaySpeed('%s') = %f, %v; expected %f (error: %t)", tt.in, out, err, tt.out, tt.err)
+		}
+	}
+}
+
+func TestReplayLogChan(t *testing.T) {
+	logTime := time.Date(2015, 3, 29, 12, 0, 0, 0, time.UTC)
+
+	in := make(chan LogLine, 10)
+	out := make(chan LogLine, 10)
+
+	in <- NewLogLine(logTime, "first", nil)
+	in <- NewLogLine(logTime.Add(10*time.Second), "second", nil)
+	in <- NewLogLine(logTime.Add(5*time.Second), "back in time", nil)
+	close(in)
+
+	// 10s at 200x takes 50ms
+	start := time.Now()
+	go ReplayLogChan(context.Background(), 200, true, in, out)
+
+	names := []string{}
+	for l := range out {
+		names = append(names, l.Name)
+
+		if l.Time.Before(start) {
+			t.Errorf("Expected `%s` to get a new time, got %s", l.Name, l.Time)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected replay to take 50ms, took %s", elapsed)
+	}
+
+	if len(names) != 3 || names[2] != "back in time" {
+		t.Errorf("Expected all lines in order, got %v", names)
+	}
+}
+
+func TestReplayLogChanCancel(t *testing.T) {
+	logTime := time.Date(2015, 3, 29, 12, 0, 0, 0, time.UTC)
+
+	in := make(chan LogLine, 10)
+	out := make(chan LogLine, 10)
+
+	in <- NewLogLine(logTime, "first", nil)
+	in <- NewLogLine(logTime.Add(time.Hour), "much later", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go ReplayLogChan(ctx, 1, false, in, out)
+
+	if l := <-out; !l.Time.Equal(logTime) {
+		t.Errorf("Expected the time to be kept, got %s", l.Time)
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Errorf("Expected no more lines after cancelling")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Replay did not stop when cancelled")
+	}
+}
@@ -2,17 +2,37 @@ package logmunch
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-func outputLinesAndCloseChan(in io.ReadCloser, out chan<- string) error {
+// Send a line on `out`, unless the context is cancelled first.
+func sendLine(ctx context.Context, out chan<- string, line string) error {
+	select {
+	case out <- line:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func outputLinesAndCloseChan(ctx context.Context, in io.ReadCloser, out chan<- string) error {
 	defer in.Close()
 
+	// Close the input when cancelled, so we aren't stuck waiting for data
+	stop := context.AfterFunc(ctx, func() { in.Close() })
+	defer stop()
+
 	// Work on them lines!
 	scanner := bufio.NewScanner(in)
 	for scanner.Scan() {
@@ -21,10 +41,17 @@ func outputLinesAndCloseChan(in io.ReadCloser, out chan<- string) error {
 			//sendBytes = make([]byte, len(b))
 			//copy(sendBytes, b)
 			// TODO: Does this do a copy?
-			out <- string(b)
+			if err := sendLine(ctx, out, string(b)); err != nil {
+				return err
+			}
 		}
 	}
 
+	// Reading fails once the input is closed; report why we closed it.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if err := scanner.Err(); err != nil {
 		return err
 	}
@@ -32,12 +59,15 @@ func outputLinesAndCloseChan(in io.ReadCloser, out chan<- string) error {
 	return nil
 }
 
-type Source func(config *url.URL, query Query, out chan<- string) (Query, error)
+// Fetches raw log lines and puts them on `out`, closing it when done.
+//
+// Sources must stop and return `ctx.Err()` when the context is cancelled.
+// Any part of the `Query` handled by the source itself should be reset in the
+// returned one.
+type Source func(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error)
 
-// Get data from a file
-func FileSource(config *url.URL, query Query, out chan<- string) (Query, error) {
-	defer close(out)
-	var file *os.File
+// Get the file name from a source URL
+func fileSourcePath(config *url.URL) string {
 	name := config.Path
 
 	// We can't give relative urls `file:./relative.file`, but
@@ -47,108 +77,331 @@ func FileSource(config *url.URL, query Query, out chan<- string) (Query, error)
 		name = name[1:]
 	}
 
-	if name == "-" || name == "" {
-		file = os.Stdin
-	} else {
-		var err error
-		file, err = os.Open(name)
+	return name
+}
 
-		if err != nil {
-			return query, err
-		}
+// Open a file for reading, decompressing it if needed
+func openFile(name string) (io.ReadCloser, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
 	}
 
-	err := outputLinesAndCloseChan(file, out)
-	return query, err
+	reader, err := decompressReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return reader, nil
 }
 
-// Get data from logentries
-func LogEntriesSource(config *url.URL, query Query, out chan<- string) (Query, error) {
-	defer close(out)
+// Expand a file name, glob pattern or directory to the list of files it
+// covers. Directories are not recursed into.
+func expandFileNames(name string) ([]string, error) {
+	// Existing files and directories are taken as-is
+	if info, err := os.Stat(name); err == nil {
+		if !info.IsDir() {
+			return []string{name}, nil
+		}
 
-	if config.User == nil {
-		return query, errors.New("No LogEntries password set!")
+		name = filepath.Join(name, "*")
 	}
 
-	password, gotPassword := config.User.Password()
+	matches, err := filepath.Glob(name)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(matches))
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() || strings.HasPrefix(filepath.Base(match), ".") {
+			continue
+		}
+		files = append(files, match)
+	}
 
-	if !gotPassword {
-		return query, errors.New("No LogEntries password set!")
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No files matching '%s'.", name)
 	}
 
-	logentriesurl := fmt.Sprintf(
-		"https://pull.logentries.com/%s/hosts/%s/?start=%d&end=%d",
-		password,
-		config.Path,
-		query.Start.Unix()*1000,
-		query.End.Unix()*1000,
-	)
+	return files, nil
+}
 
-	// Add filter if one is given
-	if query.Filter != "" {
-		logentriesurl = logentriesurl + "&filter=" + url.QueryEscape(query.Filter)
+// Get data from a file (or stdin). Gzip, bzip2 and zstd compressed input is
+// detected and decompressed on the fly.
+//
+// Given a directory or a glob pattern, all the matched files are read
+// concurrently and their lines merged into one stream ordered by time.
+//
+// With `?follow=true`, a single file is followed like `tail -F`. If it
+// doesn't exist yet, it is read once it does.
+//
+// Lines from several files are ordered by the timestamps the default
+// LogParser finds; see NewFileSource for others.
+func FileSource(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+	return readFiles(ctx, LogParser{}, config, query, out)
+}
 
-		// Re-set filter, as we process it here
-		query.Filter = ""
+// Make a file-source ordering lines from several files by the timestamps
+// `parser` finds in them.
+func NewFileSource(parser LogParser) Source {
+	return func(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+		return readFiles(ctx, parser, config, query, out)
 	}
+}
 
-	if query.Limit > 0 {
-		logentriesurl = fmt.Sprintf("%s&limit=%d", logentriesurl, query.Limit)
+func readFiles(ctx context.Context, parser LogParser, config *url.URL, query Query, out chan<- string) (Query, error) {
+	defer close(out)
+	name := fileSourcePath(config)
 
-		// Re-set limit, as we process it here
-		query.Limit = -1
+	if name == "-" || name == "" {
+		reader, err := decompressReader(os.Stdin)
+		if err != nil {
+			return query, err
+		}
+
+		err = outputLinesAndCloseChan(ctx, reader, out)
+		return query, err
 	}
 
-	resp, err := http.Get(logentriesurl)
+	follow, _ := strconv.ParseBool(config.Query().Get("follow"))
 
+	names, err := expandFileNames(name)
+	if err != nil && follow && !strings.ContainsAny(name, `*?[\`) {
+		// Wait for it to show up, like when it's rotated away
+		names, err = []string{name}, nil
+	}
 	if err != nil {
-		close(out)
 		return query, err
-	} else if resp.StatusCode != 200 {
-		return query, fmt.Errorf("Logentries returned HTTP %d for %s", resp.StatusCode, logentriesurl)
 	}
 
-	err = outputLinesAndCloseChan(resp.Body, out)
-	return query, err
+	if follow {
+		if len(names) != 1 {
+			return query, fmt.Errorf("Can only follow a single file, '%s' matches %d.", name, len(names))
+		}
+
+		// Like `tail -f`, unless asked for older lines
+		err = followFile(ctx, names[0], !query.Start.IsZero(), out)
+		return query, err
+	}
+
+	// Open everything up front, so missing files are reported before we
+	// start emitting lines
+	readers := make([]io.ReadCloser, 0, len(names))
+	for _, name := range names {
+		reader, err := openFile(name)
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return query, err
+		}
+		readers = append(readers, reader)
+	}
+
+	if len(readers) == 1 {
+		err = outputLinesAndCloseChan(ctx, readers[0], out)
+		return query, err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(readers))
+	chans := make([]<-chan string, len(readers))
+
+	for i, reader := range readers {
+		c := make(chan string, 100)
+		chans[i] = c
+
+		wg.Add(1)
+		go func(reader io.ReadCloser, c chan<- string) {
+			defer wg.Done()
+			defer close(c)
+			if err := outputLinesAndCloseChan(ctx, reader, c); err != nil {
+				errs <- err
+			}
+		}(reader, c)
+	}
+
+	// When cancelled, the readers stop by themselves.
+	mergeErr := mergeLinesByTime(ctx, parser, chans, out)
+	wg.Wait()
+	close(errs)
+
+	if mergeErr != nil {
+		return query, mergeErr
+	}
+	return query, <-errs
+}
+
+// Sources available to all SourceLoaders, by URL scheme, and the parts of
+// the Query they handle themselves
+var (
+	sourcesLock   sync.RWMutex
+	sources       = make(map[string]Source)
+	sourceHandles = make(map[string]QueryField)
+)
+
+func init() {
+	RegisterSource("logentries", LogEntriesSource, QueryFilter, QueryLimit, QueryTimeRange)
+	RegisterSource("file", FileSource)
+	RegisterSource("syslog", SyslogSource)
+	RegisterSource("logplex", LogplexSource)
+	RegisterSource("loki", LokiSource)
+	RegisterSource("otlp", OTLPSource)
+	RegisterSource("http", HTTPSource)
+	RegisterSource("https", HTTPSource)
+	RegisterSource("exec", ExecSource)
+	RegisterSource("docker", DockerSource)
+	RegisterSource("cri", CRISource)
+	RegisterSource("journal", JournalSource)
+}
+
+// Make a Source available for the given URL scheme in all SourceLoaders.
+//
+// The given fields are the parts of the Query the source takes care of
+// itself (ex. by passing them on to a server); the rest is left to be
+// enforced after parsing (see SourceLoader.Unhandled).
+//
+// Panics if the scheme is already registered or source is nil.
+func RegisterSource(scheme string, source Source, handles ...QueryField) {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+
+	if source == nil {
+		panic("logmunch: RegisterSource source is nil")
+	}
+	if _, dup := sources[scheme]; dup {
+		panic("logmunch: RegisterSource called twice for scheme " + scheme)
+	}
+
+	sources[scheme] = source
+	sourceHandles[scheme] = combineQueryFields(handles)
+}
+
+// List the globally registered URL schemes, sorted.
+func RegisteredSources() []string {
+	sourcesLock.RLock()
+	defer sourcesLock.RUnlock()
+
+	schemes := make([]string, 0, len(sources))
+	for scheme := range sources {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
 }
 
 // Keep a map of protocols -> default settings, all parsed from URLs
 type SourceLoader struct {
 	Config map[string]url.URL
+
+	// Named sources from the config file
+	Profiles map[string]Profile
+
+	// Sources only available in this loader; they take precedence over
+	// the globally registered ones.
+	Sources map[string]Source
+
+	// The parts of the Query handled by the loader's own Sources
+	sourceHandles map[string]QueryField
+
+	// If set, remote sources are cached here
+	Cache *SourceCache
+
+	// How GetLogLines and GetMergedLogLines parse lines; also used by
+	// GetData when the cache needs the time of lines. Register
+	// NewFileSource(Parser) as `file` to order files by it too.
+	Parser LogParser
+}
+
+// Make a Source available for the given URL scheme in this loader only. See
+// RegisterSource for `handles`.
+func (s *SourceLoader) Register(scheme string, source Source, handles ...QueryField) {
+	if s.Sources == nil {
+		s.Sources = make(map[string]Source)
+	}
+	if s.sourceHandles == nil {
+		s.sourceHandles = make(map[string]QueryField)
+	}
+
+	s.Sources[scheme] = source
+	s.sourceHandles[scheme] = combineQueryFields(handles)
+}
+
+// Get the part of the query the source for `configUrl` will not handle by
+// itself, and which must be enforced afterwards (ex. using MakeQueryFilters).
+func (s SourceLoader) Unhandled(configUrl string, query Query) (Query, error) {
+	_, config, err := s.GetConfig(configUrl)
+	if err != nil {
+		return query, err
+	}
+
+	return query.Without(s.handles(config.Scheme)), nil
+}
+
+// The parts of the Query the source for `scheme` handles
+func (s SourceLoader) handles(scheme string) QueryField {
+	if _, found := s.Sources[scheme]; found {
+		return s.sourceHandles[scheme]
+	}
+
+	sourcesLock.RLock()
+	defer sourcesLock.RUnlock()
+	return sourceHandles[scheme]
+}
+
+// List the URL schemes this loader knows, sorted.
+func (s SourceLoader) Schemes() []string {
+	schemes := RegisteredSources()
+	known := make(map[string]bool, len(schemes))
+	for _, scheme := range schemes {
+		known[scheme] = true
+	}
+
+	for scheme := range s.Sources {
+		if !known[scheme] {
+			schemes = append(schemes, scheme)
+		}
+	}
+	sort.Strings(schemes)
+
+	return schemes
 }
 
 func (s *SourceLoader) TryLoadConfigs(filenames []string) error {
-	// Create config map if not set
+	// Create config maps if not set
 	if s.Config == nil {
 		s.Config = make(map[string]url.URL)
 	}
+	if s.Profiles == nil {
+		s.Profiles = make(map[string]Profile)
+	}
 
-	// Load files
+	// Load files, reporting the first one with problems
+	var loadErr error
 	for _, filename := range filenames {
 		// Load it
-		file, err := os.Open(filename)
+		data, err := os.ReadFile(filename)
 		if err != nil {
 			continue // File doesn't exist
 		}
-		defer file.Close()
 
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			u, err := url.Parse(scanner.Text())
-			if err == nil {
-				s.Config[u.Scheme] = *u
-			}
-		}
-
-		if err := scanner.Err(); err != nil {
-			return err
+		if err := s.loadConfig(data); err != nil && loadErr == nil {
+			loadErr = fmt.Errorf("%s: %s", filename, err)
 		}
 	}
 
-	return nil
+	return loadErr
 }
 
 func (s SourceLoader) GetConfig(configUrl string) (Source, *url.URL, error) {
+	if profile, found := s.Profiles[configUrl]; found {
+		configUrl = profile.Source
+	}
+
 	u, err := url.Parse(configUrl)
 
 	if err != nil {
@@ -171,6 +424,11 @@ func (s SourceLoader) GetConfig(configUrl string) (Source, *url.URL, error) {
 			Fragment: defaultConfig.Fragment,
 		}
 
+		// Defaults like `exec:some command` also end up in the opaque section
+		if newConf.Path == "" {
+			newConf.Path = defaultConfig.Opaque
+		}
+
 		if u.User != nil {
 			newConf.User = u.User
 		}
@@ -196,25 +454,154 @@ func (s SourceLoader) GetConfig(configUrl string) (Source, *url.URL, error) {
 	}
 
 	// Return Source
-	switch u.Scheme {
-	case "logentries":
-		return LogEntriesSource, u, nil
-		//case "stdin":
-	case "file":
-		return FileSource, u, nil
-		//return SourceFile{}, nil
+	if source, found := s.Sources[u.Scheme]; found {
+		return source, u, nil
+	}
+
+	sourcesLock.RLock()
+	source, found := sources[u.Scheme]
+	sourcesLock.RUnlock()
+
+	if found {
+		return source, u, nil
 	}
 
 	// No known source bu-hu.
 	return nil, nil, errors.New(fmt.Sprintf("Unknown source '%s'.", u.Scheme))
 }
 
-func (s SourceLoader) GetData(configUrl string, query Query, out chan<- string) (Query, error) {
+func (s SourceLoader) GetData(ctx context.Context, configUrl string, query Query, out chan<- string) (Query, error) {
 	sourceFunc, config, err := s.GetConfig(configUrl)
 	if err != nil {
 		close(out)
 		return query, err
 	}
 
-	return sourceFunc(config, query, out)
+	if s.Cache != nil {
+		sourceFunc = s.Cache.Wrap(config.Scheme, sourceFunc, s.Parser)
+	}
+
+	// The parts the source is registered as handling are reset, even if the
+	// source itself doesn't, so the result is what is left for the caller
+	left, err := sourceFunc(ctx, config, query, out)
+	return left.Without(s.handles(config.Scheme)), err
+}
+
+// Fetch and parse lines from a single source, enforcing the parts of the
+// query it doesn't handle itself (see Unhandled). Fetching stops once the
+// limit is reached.
+//
+// Note: Closes `out` when done.
+func (s SourceLoader) GetLogLines(ctx context.Context, configUrl string, query Query, out chan<- LogLine) error {
+	unhandled, err := s.Unhandled(configUrl, query)
+	if err != nil {
+		close(out)
+		return err
+	}
+
+	// Fetching is stopped when the limit is reached
+	fetchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	lines := make(chan string, 100)
+	logs := make(chan LogLine, 100)
+
+	// The source closes `lines` when stopped, so the rest is parsed and
+	// filtered away after the limit
+	go s.Parser.ParseLogEntries(ctx, lines, logs)
+	go FilterLogChan(ctx, MakeQueryFilters(unhandled, stop), logs, out)
+
+	_, err = s.GetData(fetchCtx, configUrl, query, lines)
+	if err != nil && fetchCtx.Err() != nil && ctx.Err() == nil {
+		return nil
+	}
+	return err
+}
+
+// Fetch and parse lines from several sources at once, merging them into one
+// stream ordered by time (see MergeLogLines for `window`). Each line is
+// tagged with the source it came from in the `_source` key. (Lines from a
+// single source don't need telling apart, so GetData doesn't tag them.)
+//
+// The parts of the query a source doesn't handle itself are enforced for it,
+// except the limit, which is left to the caller to apply on the merged
+// stream.
+//
+// Note: Closes `out` when all sources are done.
+func (s SourceLoader) GetMergedLogLines(ctx context.Context, configUrls []string, query Query, window time.Duration, out chan<- LogLine) error {
+	// Check all the sources before starting any of them
+	filters := make([][]Filterer, len(configUrls))
+	for i, configUrl := range configUrls {
+		unhandled, err := s.Unhandled(configUrl, query)
+		if err != nil {
+			close(out)
+			return err
+		}
+		unhandled.Limit = -1
+
+		filters[i] = append(
+			MakeQueryFilters(unhandled, nil),
+			MakeTagFilter("_source", sourceName(configUrl)),
+		)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(configUrls))
+	streams := make([]<-chan LogLine, len(configUrls))
+
+	for i, configUrl := range configUrls {
+		lines := make(chan string, 100)
+		logs := make(chan LogLine, 100)
+		tagged := make(chan LogLine, 100)
+		streams[i] = tagged
+
+		wg.Add(1)
+		go func(configUrl string) {
+			defer wg.Done()
+			if _, err := s.GetData(ctx, configUrl, query, lines); err != nil && err != ctx.Err() {
+				errs <- fmt.Errorf("%s: %s", sourceName(configUrl), err)
+			}
+		}(configUrl)
+
+		go s.Parser.ParseLogEntries(ctx, lines, logs)
+		go FilterLogChan(ctx, filters[i], logs, tagged)
+	}
+
+	MergeLogLines(ctx, streams, window, out)
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// Query parameters containing these are taken to be secrets
+var secretParams = []string{"key", "token", "secret", "pass", "auth", "sig", "credential"}
+
+// Name a source without any credentials in it; no user info, no fragment
+// (where ex. the http-source takes tokens and headers) and no query
+// parameters that look like secrets.
+func sourceName(configUrl string) string {
+	u, err := url.Parse(configUrl)
+	if err != nil {
+		return configUrl
+	}
+
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if u.RawQuery != "" {
+		params := u.Query()
+		for name := range params {
+			for _, secret := range secretParams {
+				if strings.Contains(strings.ToLower(name), secret) {
+					params.Del(name)
+					break
+				}
+			}
+		}
+		u.RawQuery = params.Encode()
+	}
+
+	return u.String()
 }
@@ -0,0 +1,239 @@
+package logmunch
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default address to listen for syslog messages on
+const defaultSyslogAddress = ":5514"
+
+// Messages larger than this are considered garbage
+const maxSyslogMessageSize = 1 << 20
+
+// Listen for syslog messages on both UDP and TCP.
+//
+// Ex. `syslog://0.0.0.0:5514` will accept messages from rsyslog configured
+// with `*.* @@127.0.0.1:5514` (TCP) or `*.* @127.0.0.1:5514` (UDP).
+func SyslogSource(ctx context.Context, config *url.URL, query Query, out chan<- string) (Query, error) {
+	defer close(out)
+
+	address := config.Host
+	if address == "" {
+		address = defaultSyslogAddress
+	}
+
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return query, err
+	}
+
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		udp.Close()
+		return query, err
+	}
+
+	err = serveSyslog(ctx, udp, tcp, out)
+	return query, err
+}
+
+// Read syslog messages from the given connections until they are closed or
+// the context is cancelled.
+//
+// Note: Does not close `out`.
+func serveSyslog(ctx context.Context, udp net.PacketConn, tcp net.Listener, out chan<- string) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+
+	stop := context.AfterFunc(ctx, func() {
+		udp.Close()
+		tcp.Close()
+	})
+	defer stop()
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs <- serveSyslogUDP(ctx, udp, out)
+		tcp.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		errs <- serveSyslogTCP(ctx, tcp, out)
+		udp.Close()
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return <-errs
+}
+
+// Every datagram is one message
+func serveSyslogUDP(ctx context.Context, conn net.PacketConn, out chan<- string) error {
+	defer conn.Close()
+	buf := make([]byte, 65536)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if msg := strings.TrimRight(string(buf[:n]), "\r\n\x00"); msg != "" {
+			if err := sendLine(ctx, out, msg); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+func serveSyslogTCP(ctx context.Context, listener net.Listener, out chan<- string) error {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	conns := make(map[net.Conn]bool)
+
+	// Hang up on all clients when the listener goes away, so we don't send
+	// anything on `out` after returning.
+	defer func() {
+		lock.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		lock.Unlock()
+		wg.Wait()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		lock.Lock()
+		conns[conn] = true
+		lock.Unlock()
+
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			readSyslogFrames(ctx, bufio.NewReader(conn), out)
+
+			lock.Lock()
+			delete(conns, conn)
+			lock.Unlock()
+			conn.Close()
+		}(conn)
+	}
+}
+
+// Split a stream into syslog messages. Both octet-counting (`LEN MSG`) and
+// newline-terminated framing is supported, as per
+// https://tools.ietf.org/html/rfc6587#section-3.4
+func readSyslogFrames(ctx context.Context, in *bufio.Reader, out chan<- string) error {
+	for {
+		if _, err := in.Peek(1); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var msg string
+
+		if length, ok := peekOctetCount(in); ok {
+			// Octet counting: MSG-LEN SP SYSLOG-MSG
+			if _, err := in.ReadString(' '); err != nil {
+				return err
+			}
+
+			buf := make([]byte, length)
+			if _, err := io.ReadFull(in, buf); err != nil {
+				return err
+			}
+			msg = string(buf)
+		} else {
+			// Non-transparent framing: SYSLOG-MSG LF
+			var err error
+			msg, err = readSyslogLine(in)
+			if err != nil && err != io.EOF {
+				return err
+			}
+		}
+
+		if msg = strings.TrimRight(msg, "\r\n\x00"); msg != "" {
+			if err := sendLine(ctx, out, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Read a newline-terminated frame. Frames longer than maxSyslogMessageSize
+// are cut off there, and the rest of them is skipped.
+func readSyslogLine(in *bufio.Reader) (string, error) {
+	var msg []byte
+	for {
+		chunk, err := in.ReadSlice('\n')
+		if room := maxSyslogMessageSize - len(msg); len(chunk) > room {
+			chunk = chunk[:room]
+		}
+		msg = append(msg, chunk...)
+
+		if err != bufio.ErrBufferFull {
+			return string(msg), err
+		}
+	}
+}
+
+// Does the next frame start with an octet count, `MSG-LEN SP <PRI>…`?
+// Returns the length if so. Frames that merely start with a digit are
+// newline-framed.
+func peekOctetCount(in *bufio.Reader) (int, bool) {
+	maxDigits := len(strconv.Itoa(maxSyslogMessageSize))
+
+	for n := 0; n <= maxDigits; n++ {
+		head, err := in.Peek(n + 1)
+		if err != nil {
+			return 0, false
+		}
+
+		c := head[n]
+		if c >= '0' && c <= '9' && !(n == 0 && c == '0') {
+			continue
+		}
+		if c != ' ' || n == 0 {
+			return 0, false
+		}
+
+		// Look past the space for the PRI
+		head, err = in.Peek(n + 2)
+		if err != nil || head[n+1] != '<' {
+			return 0, false
+		}
+
+		length, err := strconv.Atoi(string(head[:n]))
+		if err != nil || length > maxSyslogMessageSize {
+			return 0, false
+		}
+		return length, true
+	}
+
+	return 0, false
+}
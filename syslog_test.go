@@ -0,0 +1,136 @@
+package logmunch
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadSyslogFrames(t *testing.T) {
+	in := "" +
+		"<34>1 2015-06-12T00:00:01Z host app - - newline framed\n" +
+		"53 <34>1 2015-06-12T00:00:02Z host app - - octet counted" +
+		"58 <34>1 2015-06-12T00:00:03Z host app - - multi\nline message" +
+		"\n" +
+		"<34>1 2015-06-12T00:00:04Z host app - - no trailing newline"
+
+	out := make(chan string, 10)
+	err := readSyslogFrames(context.Background(), bufio.NewReader(strings.NewReader(in)), out)
+	close(out)
+
+	if err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	got := []string{}
+	for msg := range out {
+		got = append(got, msg)
+	}
+
+	expected := []string{
+		"<34>1 2015-06-12T00:00:01Z host app - - newline framed",
+		"<34>1 2015-06-12T00:00:02Z host app - - octet counted",
+		"<34>1 2015-06-12T00:00:03Z host app - - multi\nline message",
+		"<34>1 2015-06-12T00:00:04Z host app - - no trailing newline",
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected\n\t%q\ngot\n\t%q", expected, got)
+	}
+}
+
+func TestReadSyslogFramesStartingWithDigits(t *testing.T) {
+	in := "" +
+		"2015-06-12T00:00:01Z host app newline framed\n" +
+		"12 monkeys\n" +
+		"99999999999 <34>too long to be a length\n" +
+		"30 <34>1 2015-06-12T00:00:02Z x y"
+
+	out := make(chan string, 10)
+	err := readSyslogFrames(context.Background(), bufio.NewReader(strings.NewReader(in)), out)
+	close(out)
+
+	if err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	got := []string{}
+	for msg := range out {
+		got = append(got, msg)
+	}
+
+	expected := []string{
+		"2015-06-12T00:00:01Z host app newline framed",
+		"12 monkeys",
+		"99999999999 <34>too long to be a length",
+		"<34>1 2015-06-12T00:00:02Z x y",
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected\n\t%q\ngot\n\t%q", expected, got)
+	}
+}
+
+func TestReadSyslogFramesCutsLongLines(t *testing.T) {
+	long := strings.Repeat("x", maxSyslogMessageSize+100)
+	in := long + "\nnext\n"
+
+	out := make(chan string, 10)
+	err := readSyslogFrames(context.Background(), bufio.NewReader(strings.NewReader(in)), out)
+	close(out)
+
+	if err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	got := []string{}
+	for msg := range out {
+		got = append(got, msg)
+	}
+
+	expected := []string{long[:maxSyslogMessageSize], "next"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %d messages ending in %q, got %d", len(expected), "next", len(got))
+	}
+}
+
+func TestServeSyslog(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan string)
+	result := make(chan error)
+	go func() { result <- serveSyslog(context.Background(), udp, tcp, out) }()
+
+	udpClient, _ := net.Dial("udp", udp.LocalAddr().String())
+	udpClient.Write([]byte("<34>1 2015-06-12T00:00:01Z host app - - over udp\n"))
+	udpClient.Close()
+
+	if msg := <-out; msg != "<34>1 2015-06-12T00:00:01Z host app - - over udp" {
+		t.Errorf("Unexpected UDP message `%s`", msg)
+	}
+
+	tcpClient, _ := net.Dial("tcp", tcp.Addr().String())
+	tcpClient.Write([]byte("<34>1 2015-06-12T00:00:02Z host app - - over tcp\n"))
+
+	if msg := <-out; msg != "<34>1 2015-06-12T00:00:02Z host app - - over tcp" {
+		t.Errorf("Unexpected TCP message `%s`", msg)
+	}
+
+	// Closing one listener shuts down everything, including connected clients
+	tcp.Close()
+
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+	tcpClient.Close()
+}
@@ -0,0 +1,105 @@
+package logmunch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// strftime directives and the Go layouts they correspond to
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'e': "_2",
+	'f': "999999999", // Fractional seconds, after the `.` or `,`
+	'F': "2006-01-02",
+	'H': "15",
+	'I': "03",
+	'j': "002",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'S': "05",
+	'T': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'Z': "MST",
+	'%': "%",
+}
+
+// Time format for Unix timestamps; see parseEpoch
+const UnixTimeFormat = "unix"
+
+// Make a Go time layout from a Go layout or a strftime format (anything
+// with a `%` in it), ex. `%d/%b/%Y:%H:%M:%S %z`. UnixTimeFormat is kept as
+// it is.
+func ParseTimeFormat(format string) (string, error) {
+	if !strings.Contains(format, "%") {
+		return format, nil
+	}
+
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+
+		if i+1 == len(format) {
+			return "", fmt.Errorf("Time format '%s' ends with a lone %%.", format)
+		}
+
+		i++
+		directive, found := strftimeLayouts[format[i]]
+		if !found {
+			return "", fmt.Errorf("Unsupported directive %%%c in time format '%s'.", format[i], format)
+		}
+		layout.WriteString(directive)
+	}
+
+	return layout.String(), nil
+}
+
+// Parse Unix timestamps in seconds (optionally with decimals), milli-, micro-
+// or nanoseconds, telling them apart by the number of digits.
+func parseEpoch(value string) (time.Time, bool) {
+	whole, fraction, hasFraction := strings.Cut(value, ".")
+
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return time.Time{}, false
+		}
+	}
+
+	if hasFraction {
+		if len(whole) != 10 || fraction == "" {
+			return time.Time{}, false
+		}
+		seconds, _ := strconv.ParseInt(whole, 10, 64)
+		nanos, _ := strconv.ParseInt((fraction + "000000000")[:9], 10, 64)
+		return time.Unix(seconds, nanos).UTC(), true
+	}
+
+	n, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	switch len(whole) {
+	case 10:
+		return time.Unix(n, 0).UTC(), true
+	case 13:
+		return time.UnixMilli(n).UTC(), true
+	case 16:
+		return time.UnixMicro(n).UTC(), true
+	case 19:
+		return time.Unix(0, n).UTC(), true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,161 @@
+package logmunch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeFormat(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{in: "2006-01-02 15:04:05", out: "2006-01-02 15:04:05"},
+		{in: "%d/%b/%Y:%H:%M:%S %z", out: "02/Jan/2006:15:04:05 -0700"},
+		{in: "%Y-%m-%d %H:%M:%S,%f", out: "2006-01-02 15:04:05,999999999"},
+		{in: "%F %T 100%%", out: "2006-01-02 15:04:05 100%"},
+		{in: "%Y-%m-%d %Q", err: true},
+		{in: "%Y%", err: true},
+	}
+
+	for _, tt := range tests {
+		out, err := ParseTimeFormat(tt.in)
+		if (err != nil) != tt.err || out != tt.out {
+			t.Errorf("ParseTimeFormat('%s') = '%s', %v; expected '%s' (error: %t)", tt.in, out, err, tt.out, tt.err)
+		}
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	expected := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var tests = []struct {
+		in  string
+		out time.Time
+		ok  bool
+	}{
+		{"1546398245", expected, true},
+		{"1546398245.5", expected.Add(500 * time.Millisecond), true},
+		{"1546398245123", expected.Add(123 * time.Millisecond), true},
+		{"1546398245123456", expected.Add(123456 * time.Microsecond), true},
+		{"1546398245123456789", expected.Add(123456789), true},
+		{"200", time.Time{}, false},
+		{"15463982451", time.Time{}, false},
+		{"1546398245.", time.Time{}, false},
+		{"-546398245", time.Time{}, false},
+		{"2019-01-02", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		out, ok := parseEpoch(tt.in)
+		if ok != tt.ok || !out.Equal(tt.out) {
+			t.Errorf("parseEpoch('%s') = %s, %t; expected %s, %t", tt.in, out, ok, tt.out, tt.ok)
+		}
+	}
+}
+
+func TestLogParserTimeFormats(t *testing.T) {
+	copenhagen, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Skip("No timezone data:", err)
+	}
+
+	nginx, _ := ParseTimeFormat("%d/%b/%Y:%H:%M:%S %z")
+	python, _ := ParseTimeFormat("%Y-%m-%d %H:%M:%S,%f")
+
+	var tests = []struct {
+		parser LogParser
+		in     string
+		out    LogLine
+	}{
+		{
+			parser: LogParser{TimeLayout: nginx},
+			in:     `10.0.0.1 - - [02/Jan/2019:04:04:05 +0100] status=200`,
+			out: LogLine{
+				Time:    time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+				Name:    "10.0.0.1 - -",
+				Entries: map[string]string{"status": "200"},
+			},
+		},
+		{
+			parser: LogParser{TimeLayout: python, Location: copenhagen},
+			in:     `2019-01-02 04:04:05,250 INFO worker job=7`,
+			out: LogLine{
+				Time:    time.Date(2019, 1, 2, 3, 4, 5, 250000000, time.UTC),
+				Name:    "INFO worker",
+				Entries: map[string]string{"job": "7"},
+			},
+		},
+		{
+			parser: LogParser{},
+			in:     `1546398245123 worker job=7`,
+			out: LogLine{
+				Time:    time.Date(2019, 1, 2, 3, 4, 5, 123000000, time.UTC),
+				Name:    "worker",
+				Entries: map[string]string{"job": "7"},
+			},
+		},
+		// Ids in front of a timestamp aren't one
+		{
+			parser: LogParser{},
+			in:     `1234567890 abc 2015-06-12T00:00:00Z msg=x`,
+			out: LogLine{
+				Time:    time.Date(2015, 6, 12, 0, 0, 0, 0, time.UTC),
+				Name:    "1234567890 abc",
+				Entries: map[string]string{"msg": "x"},
+			},
+		},
+		{
+			parser: LogParser{TimeLayout: UnixTimeFormat},
+			in:     `worker 1546398245 job=7`,
+			out: LogLine{
+				Time:    time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+				Name:    "worker",
+				Entries: map[string]string{"job": "7"},
+			},
+		},
+		{
+			parser: LogParser{TimeKey: "ts"},
+			in:     `worker {"ts": 1546398245.5, "job": 7}`,
+			out: LogLine{
+				Time:    time.Date(2019, 1, 2, 3, 4, 5, 500000000, time.UTC),
+				Name:    "worker",
+				Entries: map[string]string{"job": "7"},
+			},
+		},
+		{
+			parser: LogParser{TimeKey: "at", TimeLayout: python},
+			in:     `worker at="2019-01-02 03:04:05,000" job=7`,
+			out: LogLine{
+				Time:    time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+				Name:    "worker",
+				Entries: map[string]string{"job": "7"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := tt.parser.ParseLine(tt.in)
+		if err != nil {
+			t.Errorf("Could not parse `%s`: %s", tt.in, err)
+		} else if !out.Equal(tt.out) {
+			t.Errorf("Expected `%s` to parse as\n\t%#v\nbut got\n\t%#v", tt.in, tt.out, out)
+		}
+	}
+
+	// Without a Location, timestamps without a zone are UTC as they always
+	// were, whatever the local timezone
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = copenhagen
+
+	out, err := LogParser{TimeLayout: python}.ParseLine(`2019-01-02 03:04:05,000 worker`)
+	if err != nil || !out.Time.Equal(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Expected a UTC time, got %s (%v)", out.Time, err)
+	}
+
+	// Numbers later in the line aren't timestamps
+	if _, err := parseLogLine("worker id 1546398245123"); err != errNoTimestamp {
+		t.Errorf("Expected errNoTimestamp, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package logmunch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Absolute times we understand; the ones without a zone are read in the
+// location given to ParseTime.
+var absoluteTimeFormats = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Times of day, taken to mean today (or the day given before it)
+var timeOfDayFormats = []string{
+	"15:04:05",
+	"15:04",
+}
+
+// Go durations extended with days and weeks, ex. `-3d`, `-1w2d12h`
+var relativeTimeRegexp = regexp.MustCompile(`^([+-]?)(?:(\d+)w)?(?:(\d+)d)?(.*)$`)
+
+// Parse a human-friendly time, relative to `now`:
+//
+//   - Relative durations, ex. `-1h30m`, `-3d` or `-1w`.
+//   - RFC3339 timestamps, ex. `2026-10-13T14:05:00Z`.
+//   - Dates and times, ex. `2026-10-13`, `2026-10-13 14:05` or `14:05`.
+//   - `now`, `today`, `yesterday` and ex. `yesterday 14:05`.
+//   - Unix timestamps, ex. `@1760364300`.
+//
+// Dates and times without a zone are read in `loc`. An empty string gives
+// the zero time.
+func ParseTime(value string, now time.Time, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	now = now.In(loc)
+
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	// Unix timestamps
+	if strings.HasPrefix(value, "@") {
+		seconds, err := strconv.ParseFloat(value[1:], 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid unix timestamp '%s'.", value)
+		}
+		return time.Unix(0, int64(seconds*1e9)), nil
+	}
+
+	// Named days, optionally followed by a time of day
+	day, timeOfDay := value, ""
+	if i := strings.IndexRune(value, ' '); i != -1 {
+		day, timeOfDay = value[:i], strings.TrimSpace(value[i+1:])
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	switch strings.ToLower(day) {
+	case "now":
+		if timeOfDay == "" {
+			return now, nil
+		}
+	case "today":
+		return addTimeOfDay(midnight, timeOfDay, value)
+	case "yesterday":
+		return addTimeOfDay(midnight.AddDate(0, 0, -1), timeOfDay, value)
+	case "tomorrow":
+		return addTimeOfDay(midnight.AddDate(0, 0, 1), timeOfDay, value)
+	}
+
+	for _, format := range absoluteTimeFormats {
+		if t, err := time.ParseInLocation(format, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	if t, err := addTimeOfDay(midnight, value, value); err == nil {
+		return t, nil
+	}
+
+	if d, err := parseRelativeDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Cannot parse time '%s'.", value)
+}
+
+// Add a `15:04`-style time of day to the given midnight
+func addTimeOfDay(midnight time.Time, timeOfDay string, value string) (time.Time, error) {
+	if timeOfDay == "" {
+		return midnight, nil
+	}
+
+	for _, format := range timeOfDayFormats {
+		if t, err := time.Parse(format, timeOfDay); err == nil {
+			return time.Date(
+				midnight.Year(), midnight.Month(), midnight.Day(),
+				t.Hour(), t.Minute(), t.Second(), 0,
+				midnight.Location(),
+			), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Cannot parse time '%s'.", value)
+}
+
+// Parse a Go duration, also allowing days (`d`) and weeks (`w`)
+func parseRelativeDuration(value string) (time.Duration, error) {
+	match := relativeTimeRegexp.FindStringSubmatch(value)
+	if match == nil || match[2]+match[3]+match[4] == "" {
+		return 0, fmt.Errorf("Invalid duration '%s'.", value)
+	}
+
+	var d time.Duration
+	if match[2] != "" {
+		weeks, _ := strconv.Atoi(match[2])
+		d += time.Duration(weeks) * 7 * 24 * time.Hour
+	}
+	if match[3] != "" {
+		days, _ := strconv.Atoi(match[3])
+		d += time.Duration(days) * 24 * time.Hour
+	}
+	if match[4] != "" {
+		rest, err := time.ParseDuration(match[4])
+		if err != nil || rest < 0 {
+			return 0, fmt.Errorf("Invalid duration '%s'.", value)
+		}
+		d += rest
+	}
+
+	if match[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
@@ -0,0 +1,71 @@
+package logmunch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	cph, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Skip("No timezone data:", err)
+	}
+
+	now := time.Date(2026, 10, 17, 12, 30, 0, 0, time.UTC)
+
+	var tests = []struct {
+		in  string
+		loc *time.Location
+		out time.Time
+	}{
+		{"", time.UTC, time.Time{}},
+		{"now", time.UTC, now},
+		{"-1h30m", time.UTC, now.Add(-90 * time.Minute)},
+		{"-3d", time.UTC, now.AddDate(0, 0, -3)},
+		{"-1w2d12h", time.UTC, now.Add(-9*24*time.Hour - 12*time.Hour)},
+		{"0", time.UTC, now},
+		{"@1760364300", time.UTC, time.Unix(1760364300, 0)},
+		{"2026-10-13T14:05:00Z", cph, time.Date(2026, 10, 13, 14, 5, 0, 0, time.UTC)},
+		{"2026-10-13T14:05:00+02:00", time.UTC, time.Date(2026, 10, 13, 12, 5, 0, 0, time.UTC)},
+		{"2026-10-13 14:05", time.UTC, time.Date(2026, 10, 13, 14, 5, 0, 0, time.UTC)},
+		{"2026-10-13 14:05", cph, time.Date(2026, 10, 13, 12, 5, 0, 0, time.UTC)},
+		{"2026-10-13", time.UTC, time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC)},
+		{"14:05", time.UTC, time.Date(2026, 10, 17, 14, 5, 0, 0, time.UTC)},
+		{"today", time.UTC, time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"yesterday", time.UTC, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{"yesterday 14:05", cph, time.Date(2026, 10, 16, 12, 5, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		out, err := ParseTime(tt.in, now, tt.loc)
+		if err != nil {
+			t.Errorf("ParseTime(%s) error: %s", tt.in, err)
+		} else if !out.Equal(tt.out) {
+			t.Errorf("ParseTime(%s): expected %s, got %s", tt.in, tt.out, out)
+		}
+	}
+
+	for _, in := range []string{"soon", "-3x", "@abc", "yesterday noon", "1h-2m"} {
+		if _, err := ParseTime(in, now, time.UTC); err == nil {
+			t.Errorf("Expected ParseTime(%s) to fail", in)
+		}
+	}
+}
+
+func TestQuerySetTimeRange(t *testing.T) {
+	q := Query{}
+
+	if err := q.SetTimeRange("2026-10-13 14:05", "2026-10-13 14:40", time.UTC); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if !q.Start.Equal(time.Date(2026, 10, 13, 14, 5, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected start %s", q.Start)
+	}
+	if !q.End.Equal(time.Date(2026, 10, 13, 14, 40, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected end %s", q.End)
+	}
+
+	if err := q.SetTimeRange("today", "yesterday", time.UTC); err == nil {
+		t.Errorf("Expected an error when end is before start")
+	}
+}